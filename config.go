@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
+)
+
+// configFileNames are searched for, in order, in the working directory and
+// then the home directory when --config is not given.
+var configFileNames = []string{".resizer.yaml", ".resizer.yml", ".resizer.toml"}
+
+// withConfigFile wraps each flag so its default can come from a config file.
+// Values given on the command line still take precedence.
+func withConfigFile(flags []cli.Flag) []cli.Flag {
+	wrapped := make([]cli.Flag, 0, len(flags))
+	for _, flag := range flags {
+		switch f := flag.(type) {
+		case *cli.BoolFlag:
+			wrapped = append(wrapped, altsrc.NewBoolFlag(f))
+		case *cli.IntFlag:
+			wrapped = append(wrapped, altsrc.NewIntFlag(f))
+		case *cli.Int64Flag:
+			wrapped = append(wrapped, altsrc.NewInt64Flag(f))
+		case *cli.Float64Flag:
+			wrapped = append(wrapped, altsrc.NewFloat64Flag(f))
+		case *cli.DurationFlag:
+			wrapped = append(wrapped, altsrc.NewDurationFlag(f))
+		case *cli.StringSliceFlag:
+			wrapped = append(wrapped, altsrc.NewStringSliceFlag(f))
+		case *cli.StringFlag:
+			if f.Name == "config" {
+				wrapped = append(wrapped, f)
+				continue
+			}
+			wrapped = append(wrapped, altsrc.NewStringFlag(f))
+		default:
+			wrapped = append(wrapped, flag)
+		}
+	}
+	return wrapped
+}
+
+// applyConfigFile sets flags the command line left unset from the config
+// file. The root command and each subcommand call it for their own flags.
+func applyConfigFile(c *cli.Context, flags []cli.Flag) error {
+	source, err := loadConfigSource(c)
+	if err != nil {
+		return err
+	}
+	return altsrc.ApplyInputSourceValues(c, source, flags)
+}
+
+// loadConfigSource reads the file named by --config, or the first default
+// config file found. Having no config file at all is not an error.
+func loadConfigSource(c *cli.Context) (altsrc.InputSourceContext, error) {
+	path := c.String("config")
+	if path == "" {
+		path = findConfigFile()
+	}
+	if path == "" {
+		return altsrc.NewMapInputSource("", map[interface{}]interface{}{}), nil
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	logDebug("Loading defaults from " + path)
+	var source altsrc.InputSourceContext
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		source, err = altsrc.NewTomlSourceFromFile(path)
+	} else {
+		source, err = altsrc.NewYamlSourceFromFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return numericStrings{source}, nil
+}
+
+// numericStrings lets config files give plain numbers for string flags, so
+// "quality: 85" keeps working now that --quality also accepts per-format
+// values.
+type numericStrings struct {
+	altsrc.InputSourceContext
+}
+
+func (s numericStrings) String(name string) (string, error) {
+	value, err := s.InputSourceContext.String(name)
+	if err == nil {
+		return value, nil
+	}
+	if n, intErr := s.InputSourceContext.Int(name); intErr == nil {
+		return strconv.Itoa(n), nil
+	}
+	return "", err
+}
+
+func findConfigFile() string {
+	dirs := []string{"."}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, home)
+	}
+
+	for _, dir := range dirs {
+		for _, name := range configFileNames {
+			path := filepath.Join(dir, name)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// duplicateIndex remembers the content of every file processed in a run, for
+// --dedupe.
+type duplicateIndex struct {
+	mu    sync.Mutex
+	files map[string]string
+}
+
+func newDuplicateIndex() *duplicateIndex {
+	return &duplicateIndex{files: map[string]string{}}
+}
+
+// claim records path and returns "" if no file with the same contents has
+// been claimed before, or the path of the file that was.
+func (d *duplicateIndex) claim(path string) (string, error) {
+	key, err := contentKey(path)
+	if err != nil {
+		return "", err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if original, ok := d.files[key]; ok {
+		return original, nil
+	}
+	d.files[key] = path
+	return "", nil
+}
+
+// contentKey identifies a file by its size and SHA-256 hash.
+func contentKey(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	return fmt.Sprintf("%d:%s", size, hex.EncodeToString(hash.Sum(nil))), nil
+}
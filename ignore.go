@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFileName is the file collectFiles reads in every directory it scans
+// for gitignore-style patterns naming files and folders to leave out.
+const ignoreFileName = ".resizerignore"
+
+// ignoreRule is one pattern line of an ignore file. base is the directory the
+// file lives in, which the pattern is matched relative to.
+type ignoreRule struct {
+	base    string
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// readIgnoreFile parses the ignore file in dir, if there is one. Like
+// .gitignore, blank lines and lines starting with # are skipped, ! re-includes
+// what an earlier pattern excluded, a trailing slash matches folders only, a
+// pattern with a slash before its end is anchored to dir, and ** matches any
+// number of folders.
+func readIgnoreFile(dir string) ([]ignoreRule, error) {
+	file, err := os.Open(filepath.Join(dir, ignoreFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		text := strings.TrimRight(scanner.Text(), " \t\r")
+		line := text
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{base: dir}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if !anchored && !strings.HasPrefix(line, "**/") {
+			line = "**/" + line
+		}
+
+		rule.pattern, err = ignorePatternRegexp(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %q: %w", file.Name(), lineNumber, text, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// ignorePatternRegexp translates a slash-separated glob into a regular
+// expression over a whole relative path. * and ? stay within one path
+// segment, while a ** segment matches zero or more whole segments.
+func ignorePatternRegexp(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		if segment == "**" {
+			if last {
+				expr.WriteString(".*")
+			} else {
+				expr.WriteString("(?:[^/]*/)*")
+			}
+			continue
+		}
+
+		for j := 0; j < len(segment); j++ {
+			switch c := segment[j]; c {
+			case '*':
+				expr.WriteString("[^/]*")
+			case '?':
+				expr.WriteString("[^/]")
+			case '\\':
+				if j+1 < len(segment) {
+					j++
+				}
+				expr.WriteString(regexp.QuoteMeta(segment[j : j+1]))
+			case '[':
+				end := strings.IndexByte(segment[j+1:], ']')
+				if end < 0 {
+					return nil, errors.New("unterminated character class")
+				}
+				class := segment[j+1 : j+1+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				expr.WriteString("[" + class + "]")
+				j += end + 1
+			default:
+				expr.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		}
+		if !last {
+			expr.WriteString("/")
+		}
+	}
+
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// isIgnored reports whether path is excluded by rules, which run from the
+// outermost ignore file to the innermost. As with .gitignore, the last
+// matching pattern wins, so a nested file can re-include what a parent
+// excluded.
+func isIgnored(path string, isDir bool, rules []ignoreRule) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		rel, err := filepath.Rel(rule.base, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		if rule.pattern.MatchString(filepath.ToSlash(rel)) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnorePatternRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.jpg", "photo.jpg", true},
+		{"*.jpg", "raw/photo.jpg", false},
+		{"photo?.png", "photo1.png", true},
+		{"photo?.png", "photo12.png", false},
+		{"**/*.jpg", "photo.jpg", true},
+		{"**/*.jpg", "a/b/photo.jpg", true},
+		{"raw/**", "raw/a/b.png", true},
+		{"raw/**/*.png", "raw/b.png", true},
+		{"raw/**/*.png", "other/raw/b.png", false},
+		{"img[0-9].gif", "img7.gif", true},
+		{"img[!0-9].gif", "img7.gif", false},
+		{`\*.png`, "*.png", true},
+		{`\*.png`, "a.png", false},
+		{"a.b", "axb", false},
+	}
+	for _, tt := range tests {
+		re, err := ignorePatternRegexp(tt.pattern)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.pattern, err)
+		}
+		if got := re.MatchString(tt.path); got != tt.want {
+			t.Errorf("%q matching %q: got %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+
+	if _, err := ignorePatternRegexp("img[0-9.gif"); err == nil {
+		t.Error("an unterminated character class was accepted")
+	}
+}
+
+func TestIsIgnored(t *testing.T) {
+	dir := t.TempDir()
+	rules := "# comment\n\n*.tmp\nthumbnails/\n/top.png\nraw/**/*.png\n!raw/keep/*.png\n\\#hash.jpg\n"
+	if err := os.WriteFile(filepath.Join(dir, ignoreFileName), []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := readIgnoreFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"a.tmp", false, true},
+		{"sub/a.tmp", false, true},
+		{"thumbnails", true, true},
+		{"sub/thumbnails", true, true},
+		{"thumbnails", false, false},
+		{"top.png", false, true},
+		{"sub/top.png", false, false},
+		{"raw/x/y.png", false, true},
+		{"raw/keep/y.png", false, false},
+		{"#hash.jpg", false, true},
+		{"photo.jpg", false, false},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, filepath.FromSlash(tt.path))
+		if got := isIgnored(path, tt.isDir, parsed); got != tt.want {
+			t.Errorf("%s (directory %v): got %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+
+	if missing, err := readIgnoreFile(t.TempDir()); err != nil || missing != nil {
+		t.Errorf("a directory without an ignore file gave %v, %v", missing, err)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"restore/pkg/resizer"
+)
+
+// infoCommand prints what the resize command would work from for each image:
+// its format, size, DPI and orientation, and the memory its bitmap takes,
+// which is the figure to compare --memory against.
+func infoCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "info",
+		Usage:     "Show the format, dimensions, DPI, orientation and decoded size of images",
+		ArgsUsage: "<file, directory or pattern>...",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:    "recursive",
+				Aliases: []string{"r"},
+				Usage:   "Inspect directories recursively",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print one JSON object per file to stdout",
+			},
+		},
+		Action: runInfo,
+	}
+}
+
+// jsonInfo is the --json form of resizer.ImageInfo.
+type jsonInfo struct {
+	Type         string `json:"type"`
+	Path         string `json:"path"`
+	Format       string `json:"format,omitempty"`
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+	Bytes        int64  `json:"bytes,omitempty"`
+	DPI          int    `json:"dpi,omitempty"`
+	Orientation  int    `json:"orientation,omitempty"`
+	DecodedBytes int64  `json:"decodedBytes,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+func runInfo(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return fmt.Errorf("no input files or directories provided")
+	}
+
+	opts := &options{recursive: c.Bool("recursive")}
+	var files []string
+	failed := 0
+	for _, path := range c.Args().Slice() {
+		matches := []string{path}
+		if isGlobPattern(path) {
+			matches, _ = filepath.Glob(path)
+			if len(matches) == 0 {
+				logError(fmt.Sprintf("Error accessing path: no files match %s", path))
+				failed++
+			}
+		}
+		for _, match := range matches {
+			if stat, err := os.Stat(match); err == nil && stat.IsDir() {
+				files = append(files, collectFiles(match, opts)...)
+			} else {
+				files = append(files, match)
+			}
+		}
+	}
+	flushMessages()
+
+	printed := false
+	for _, path := range files {
+		if c.Context.Err() != nil {
+			return cli.Exit("interrupted before all files were inspected", exitInterrupted)
+		}
+		info, err := resizer.Inspect(path)
+		if err != nil {
+			failed++
+		}
+		if c.Bool("json") {
+			printJSONInfo(path, info, err)
+			continue
+		}
+		if err != nil {
+			logError(fmt.Sprintf("Failed to inspect %s: %v", path, err))
+			flushMessages()
+			continue
+		}
+		if printed {
+			fmt.Println()
+		}
+		printInfo(path, info)
+		printed = true
+	}
+
+	if failed > 0 {
+		return cli.Exit(fmt.Sprintf("%d files could not be inspected", failed), exitFilesFailed)
+	}
+	return nil
+}
+
+func printInfo(path string, info resizer.ImageInfo) {
+	dpi := "not recorded (72 is assumed)"
+	if info.DPI > 0 {
+		dpi = fmt.Sprint(info.DPI)
+	}
+	dimensions := fmt.Sprintf("%dx%d", info.Width, info.Height)
+	orientation := "none"
+	if info.Orientation > 0 {
+		orientation = fmt.Sprint(info.Orientation)
+	}
+	// Orientations 5-8 are stored sideways.
+	if info.Orientation >= 5 {
+		dimensions += fmt.Sprintf(" (%dx%d once rotated)", info.Height, info.Width)
+	}
+
+	fmt.Println(path)
+	fmt.Printf("  Format:       %s\n", strings.ToUpper(info.Format))
+	fmt.Printf("  Dimensions:   %s\n", dimensions)
+	fmt.Printf("  DPI:          %s\n", dpi)
+	fmt.Printf("  Orientation:  %s\n", orientation)
+	fmt.Printf("  File size:    %s\n", formatBytes(info.Bytes))
+	fmt.Printf("  Decoded size: %s (%d bytes)\n", formatBytes(info.DecodedBytes), info.DecodedBytes)
+}
+
+func printJSONInfo(path string, info resizer.ImageInfo, err error) {
+	out := jsonInfo{
+		Type:         "info",
+		Path:         path,
+		Format:       info.Format,
+		Width:        info.Width,
+		Height:       info.Height,
+		Bytes:        info.Bytes,
+		DPI:          info.DPI,
+		Orientation:  info.Orientation,
+		DecodedBytes: info.DecodedBytes,
+	}
+	if err != nil {
+		out.Error = err.Error()
+	}
+	printJSON(out)
+}
@@ -1,189 +1,285 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"github.com/cheggaaa/pb/v3"
 	"github.com/inconshreveable/mousetrap"
-	"github.com/rwcarlsen/goexif/exif"
 	"image"
-	"image/jpeg"
+	"image/color"
 	"image/png"
+	"io"
 	"math"
+	"math/rand"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
-	"github.com/nfnt/resize"
 	"github.com/urfave/cli/v2"
-)
-
-type PixelFormat int
+	"github.com/urfave/cli/v2/altsrc"
+	"golang.org/x/image/tiff"
 
-const (
-	Format8bppIndexed PixelFormat = iota
-	Format24bppRgb
-	Format32bppArgb
+	"restore/pkg/resizer"
 )
 
-var messageQueue []string
-var messageMutex sync.Mutex
+// options holds the settings shared by every file processed in a run. The
+// embedded resizer.Options are passed to the library for each file; the rest
+// control which files are processed and where their outputs go.
+type options struct {
+	resizer.Options
 
-func safePrint(message string) {
-	messageMutex.Lock()
-	defer messageMutex.Unlock()
-	messageQueue = append(messageQueue, message)
+	outputDir      string
+	archive        *zipArchive
+	manifest       *csvManifest
+	state          *stateFile
+	recursive      bool
+	flatten        bool
+	followSymlinks bool
+	duplicates     *duplicateIndex
+	timeout        time.Duration
+	dpi            int
+	minWidth       int
+	minHeight      int
+	minBytes       int64
+	exclude        []string
+	noIgnoreFile   bool
+	nameTemplate   string
+	outputPattern  *regexp.Regexp
+	inputFormat    string
+	lowercaseExt   bool
+	canonicalExt   bool
+	overwrite      bool
+	inPlace        bool
+	concurrency    int
+	qualitySet     bool
+	json           bool
+	maxFiles       int
+	sample         int
+	seed           int64
+	urlTimeout     time.Duration
+	// maxDownloadSize limits each URL download; urlNames holds the file
+	// name each URL input is saved under.
+	maxDownloadSize int64
+	urlNames        map[string]string
 }
 
-func flushMessages() {
-	messageMutex.Lock()
-	defer messageMutex.Unlock()
-	for _, message := range messageQueue {
-		fmt.Println(message)
-	}
-	messageQueue = nil
-}
+// qualityNotes records the output formats already warned about ignoring
+// --quality, --target-size or --output-quality-auto, so each warning appears
+// once per run rather than once per file.
+var (
+	qualityNotes     = map[string]bool{}
+	qualityNotesLock sync.Mutex
+)
 
-func calculateMaxResolution(originalWidth, originalHeight int, pixelFormat PixelFormat, alignment int, memoryLimit int64, dpi int) (int, int) {
-	bytesPerPixel := getBytesPerPixel(pixelFormat)
-	aspectRatio := float64(originalWidth) / float64(originalHeight)
-	estimatedHeight := math.Sqrt(float64(memoryLimit) / (float64(bytesPerPixel) * aspectRatio))
+// webpFallbackNote makes the warning about saving WebP sources as PNG appear
+// once per run.
+var webpFallbackNote sync.Once
 
-	for {
-		height := int(math.Floor(estimatedHeight))
-		width := int(math.Floor(aspectRatio * float64(height)))
-		stride := (width*bytesPerPixel + alignment - 1) / alignment * alignment
-		totalMemory := int64(stride) * int64(height)
+// noteQualityIgnored warns that a quality setting has no effect on the given
+// output format. Formats that honor the setting are silently accepted.
+func noteQualityIgnored(format string, opts *options) {
+	if !opts.qualitySet && opts.TargetSize == 0 && opts.MinSSIM == 0 {
+		return
+	}
+	setting := "--quality"
+	if opts.TargetSize > 0 {
+		setting = "--target-size"
+	} else if opts.MinSSIM > 0 {
+		setting = "--output-quality-auto"
+	}
 
-		if totalMemory <= memoryLimit {
-			newWidth := width - (width % dpi)
-			newHeight := int(float64(newWidth) / aspectRatio)
-			return newWidth, newHeight
-		}
+	var note string
+	switch {
+	case format == "webp" && opts.Lossless:
+		note = fmt.Sprintf("Note: %s does not apply to lossless WebP output", setting)
+	case format == "png":
+		note = fmt.Sprintf("Note: PNG files are compressed losslessly, so %s does not apply to them; use --png-compression to trade speed for size", setting)
+	case format == "gif", format == "tiff":
+		note = fmt.Sprintf("Note: %s files are compressed losslessly, so %s does not apply to them", strings.ToUpper(format), setting)
+	case format == "bmp":
+		note = fmt.Sprintf("Note: BMP files are uncompressed, so %s does not apply to them", setting)
+	default:
+		return
+	}
 
-		estimatedHeight = math.Sqrt(float64(memoryLimit) / (float64(stride) * float64(bytesPerPixel)))
+	qualityNotesLock.Lock()
+	defer qualityNotesLock.Unlock()
+	if qualityNotes[format] {
+		return
 	}
+	qualityNotes[format] = true
+	logWarn(note)
 }
 
-func getBytesPerPixel(pixelFormat PixelFormat) int {
-	switch pixelFormat {
-	case Format8bppIndexed:
-		return 1
-	case Format24bppRgb, Format32bppArgb:
-		return 4
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+func (l logLevel) String() string {
+	switch l {
+	case levelDebug:
+		return "DEBUG"
+	case levelInfo:
+		return "INFO"
+	case levelWarn:
+		return "WARN"
 	default:
-		panic(fmt.Sprintf("Unsupported PixelFormat: %v", pixelFormat))
+		return "ERROR"
 	}
 }
 
-func getPixelFormat(fileExt string) PixelFormat {
-	switch strings.ToLower(fileExt) {
-	case ".png":
-		return Format32bppArgb
-	case ".jpg", ".jpeg":
-		return Format24bppRgb
+func parseLogLevel(name string) (logLevel, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return levelDebug, nil
+	case "info":
+		return levelInfo, nil
+	case "warn", "warning":
+		return levelWarn, nil
+	case "error":
+		return levelError, nil
 	default:
-		panic("Unsupported file format")
+		return levelInfo, fmt.Errorf("invalid log level %q (valid levels: debug, info, warn, error)", name)
 	}
 }
 
-func extractDPI(filePath string) (int, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return 0, fmt.Errorf("failed to open file: %w", err)
-	}
-	defer file.Close()
+type logMessage struct {
+	level logLevel
+	text  string
+}
 
-	e, err := exif.Decode(file)
-	if err != nil {
-		return 0, fmt.Errorf("no EXIF data or corrupted EXIF data: %w", err)
-	}
+var messageQueue []logMessage
+var messageMutex sync.Mutex
 
-	xResolution, err := e.Get(exif.XResolution)
-	if err != nil {
-		return 0, fmt.Errorf("failed to get XResolution: %w", err)
-	}
-	yResolution, err := e.Get(exif.YResolution)
-	if err != nil {
-		return 0, fmt.Errorf("failed to get YResolution: %w", err)
-	}
+// minLogLevel is the least severe level that is queued; anything below it is
+// discarded.
+var minLogLevel = levelInfo
 
-	xNum, xDen, err := xResolution.Rat2(0)
-	if err != nil {
-		return 0, fmt.Errorf("error reading XResolution: %w", err)
-	}
-	yNum, yDen, err := yResolution.Rat2(0)
-	if err != nil {
-		return 0, fmt.Errorf("error reading YResolution: %w", err)
-	}
+// messageOutput receives flushed messages. JSON mode moves them to stderr so
+// stdout only carries JSON.
+var messageOutput io.Writer = os.Stdout
 
-	x := float64(xNum) / float64(xDen)
-	y := float64(yNum) / float64(yDen)
+// streamLogs prints messages as soon as they are logged instead of queuing
+// them until the batch finishes.
+var streamLogs bool
 
-	if x == y {
-		return int(x), nil
+func safeLog(level logLevel, message string) {
+	if level < minLogLevel {
+		return
 	}
-	return int(x), nil
-}
+	messageMutex.Lock()
+	defer messageMutex.Unlock()
 
-func resizeImage(filePath, outputPath string, dryRun bool, memoryLimit int64, algorithm resize.InterpolationFunction, quality, dpi int) error {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+	entry := logMessage{level: level, text: message}
+	if streamLogs {
+		writeMessage(entry)
+		return
 	}
-	defer file.Close()
+	messageQueue = append(messageQueue, entry)
+}
 
-	img, format, err := image.Decode(file)
-	if err != nil {
-		return fmt.Errorf("failed to decode image: %w", err)
-	}
+// writeMessage prints a single message above the progress bar, if one is
+// drawn.
+func writeMessage(message logMessage) {
+	printAboveProgress(messageOutput, fmt.Sprintf("[%s] %s\n", message.level, message.text))
+}
 
-	originalWidth, originalHeight := img.Bounds().Dx(), img.Bounds().Dy()
-	pixelFormat := getPixelFormat(filepath.Ext(filePath))
-	newWidth, newHeight := calculateMaxResolution(originalWidth, originalHeight, pixelFormat, 4, memoryLimit, dpi)
+// cliLogger passes messages from the resizer package to the run's log.
+type cliLogger struct{}
 
-	if newWidth < originalWidth || newHeight < originalHeight {
-		resized := resize.Resize(uint(newWidth), uint(newHeight), img, algorithm)
-		newDPI := int(float64(newWidth) / (float64(originalWidth) / float64(dpi)))
+func (cliLogger) Debug(message string) { logDebug(message) }
+func (cliLogger) Info(message string)  { logInfo(message) }
+func (cliLogger) Warn(message string)  { logWarn(message) }
 
-		safePrint(fmt.Sprintf("Resized %s to %dx%d with a DPI of %d", filePath, newWidth, newHeight, newDPI))
+func logDebug(message string) { safeLog(levelDebug, message) }
+func logInfo(message string)  { safeLog(levelInfo, message) }
+func logWarn(message string)  { safeLog(levelWarn, message) }
+func logError(message string) { safeLog(levelError, message) }
 
-		return saveImage(resized, outputPath, format, quality)
+// logNow prints an info message straight away, even while other messages are
+// queued, for progress lines that are useless after the fact.
+func logNow(message string) {
+	if levelInfo < minLogLevel {
+		return
 	}
+	messageMutex.Lock()
+	defer messageMutex.Unlock()
+	writeMessage(logMessage{level: levelInfo, text: message})
+}
 
-	return nil
+func flushMessages() {
+	messageMutex.Lock()
+	defer messageMutex.Unlock()
+	for _, message := range messageQueue {
+		writeMessage(message)
+	}
+	messageQueue = nil
 }
 
-func saveImage(img image.Image, outputPath, format string, quality int) error {
-	outFile, err := os.Create(outputPath)
+// createdDirs remembers the mirrored output subdirectories that already
+// exist, so each is created once however many files land in it.
+var createdDirs sync.Map
+
+// prepareOutputDir creates the output directory and checks that files can be
+// written to it, so an unusable destination is reported before any work starts.
+func prepareOutputDir(dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create output directory %s: %w", dir, err)
+	}
+
+	probe, err := os.CreateTemp(dir, ".resizer-*")
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return fmt.Errorf("output directory %s is not writable: %w", dir, err)
 	}
-	defer outFile.Close()
+	probe.Close()
+	os.Remove(probe.Name())
 
-	switch format {
-	case "png":
-		if err = png.Encode(outFile, img); err != nil {
-			return fmt.Errorf("failed to encode PNG: %w", err)
-		}
-		return nil
-	case "jpeg":
-		if err = jpeg.Encode(outFile, img, &jpeg.Options{Quality: quality}); err != nil {
-			return fmt.Errorf("failed to encode JPEG: %w", err)
-		}
+	createdDirs.Store(filepath.Clean(dir), true)
+	return nil
+}
 
-	default:
-		return fmt.Errorf("unsupported output format: %s", format)
+// ensureOutputSubdir creates a mirrored subdirectory of the output directory
+// the first time a file needs it.
+func ensureOutputSubdir(dir string) error {
+	dir = filepath.Clean(dir)
+	if _, ok := createdDirs.Load(dir); ok {
+		return nil
 	}
-
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	createdDirs.Store(dir, true)
 	return nil
 }
 
+// Exit codes. Any other failure, such as an invalid option or an unusable
+// output directory, exits with exitUsage.
+const (
+	exitUsage       = 1
+	exitFilesFailed = 2
+	exitInterrupted = 130
+)
+
 func main() {
 	var args = os.Args[1:]
 	if len(args) == 0 && mousetrap.StartedByExplorer() {
-		safePrint("This application cannot be run by double-clicking it. Please run it from a console or drag your images onto the executable.")
-		safePrint("Press Enter to exit...")
+		logError("This application cannot be run by double-clicking it. Please run it from a console or drag your images onto the executable.")
+		logError("Press Enter to exit...")
+		flushMessages()
 		_, err := fmt.Scanln()
 		if err != nil {
 			return
@@ -194,181 +290,1041 @@ func main() {
 	app := &cli.App{
 		Name:  "Resizer",
 		Usage: "Resize images to fit within a memory limit",
-		Flags: []cli.Flag{
-			&cli.Int64Flag{
-				Name:    "memory",
-				Aliases: []string{"m"},
-				Usage:   "Maximum memory limit in bytes (default: 2GB)",
-				Value:   2 * 1024 * 1024 * 1024, // Default to 2GB
-			},
-			&cli.StringFlag{
-				Name:    "output",
-				Aliases: []string{"o"},
-				Usage:   "Directory to save resized images (default: current working directory)",
-				Value:   ".", // Default to the current working directory
-			},
-			&cli.StringFlag{
-				Name:    "algorithm",
-				Aliases: []string{"a"},
-				Usage:   "Resize algorithm to use (lanczos, bilinear, nearest)",
-				Value:   "lanczos",
-			},
-			&cli.IntFlag{
-				Name:    "quality",
-				Aliases: []string{"q"},
-				Usage:   "JPEG quality (1-100)",
-				Value:   75,
-			},
-			&cli.BoolFlag{
-				Name:  "dry-run",
-				Usage: "Simulate resizing without saving files",
-			},
-			&cli.BoolFlag{
-				Name:    "recursive",
-				Aliases: []string{"r"},
-				Usage:   "Process directories recursively",
-			},
-			&cli.IntFlag{
-				Name:    "dpi",
-				Aliases: []string{"d"},
-				Usage:   "Set the DPI for the output image. If not set, it will be extracted from EXIF if available",
-				Value:   0, // Default DPI is unset
-			},
+		Commands: []*cli.Command{
+			resizeCommand(),
+			convertCommand(),
+			infoCommand(),
+			benchmarkCommand(),
 		},
-		Action: func(c *cli.Context) error {
-			memoryLimit := c.Int64("memory")
-			outputDir := c.String("output")
-			algorithm := getResizeAlgorithm(c.String("algorithm"))
-			quality := c.Int("quality")
-			dryRun := c.Bool("dry-run")
-			recursive := c.Bool("recursive")
-			dpi := c.Int("dpi")
-
-			if c.NArg() == 0 {
-				return fmt.Errorf("no input files or directories provided")
-			}
+		Flags: withConfigFile(sharedFlags()),
+	}
 
-			for _, path := range c.Args().Slice() {
-				processPath(path, memoryLimit, outputDir, algorithm, quality, dryRun, recursive, dpi)
-			}
-			return nil
+	app.Before = func(c *cli.Context) error {
+		if err := applyConfigFile(c, app.Flags); err != nil {
+			return err
+		}
+		return setUpLogging(c)
+	}
+
+	// The first interrupt lets files in progress finish; a second one kills
+	// the process as usual.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		logWarn("Interrupted; finishing files in progress (press Ctrl-C again to stop immediately)")
+		flushMessages()
+	}()
+
+	// Errors are reported below so they go through the usual log output.
+	app.ExitErrHandler = func(*cli.Context, error) {}
+
+	if err := app.RunContext(ctx, withDefaultCommand(os.Args, app)); err != nil {
+		code := exitUsage
+		var exitErr cli.ExitCoder
+		if errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		}
+		if message := err.Error(); message != "" {
+			logError(message)
+		}
+		flushMessages()
+		os.Exit(code)
+	}
+}
+
+// setUpLogging applies the logging flags shared by every command.
+func setUpLogging(c *cli.Context) error {
+	level, err := parseLogLevel(c.String("log-level"))
+	if err != nil {
+		return err
+	}
+	minLogLevel = level
+	if c.Bool("verbose") && c.Bool("quiet") {
+		return fmt.Errorf("--verbose and --quiet cannot be used together")
+	}
+	if c.Bool("verbose") {
+		minLogLevel = levelDebug
+	}
+	if c.Bool("quiet") {
+		minLogLevel = levelError
+	}
+	streamLogs = c.Bool("stream-logs")
+	return nil
+}
+
+// sharedFlags returns the flags every command accepts. The resize and convert
+// commands define them again so they can follow the command's own flags, as
+// they could before the commands were split out.
+func sharedFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "verbose",
+			Aliases: []string{"v"},
+			Usage:   "Print debug messages, including sizing decisions and timings",
+		},
+		&cli.BoolFlag{
+			Name:  "quiet",
+			Usage: "Only print errors and the summary at the end of the run",
+		},
+		&cli.BoolFlag{
+			Name:  "stream-logs",
+			Usage: "Print messages as they happen instead of after each batch",
+		},
+		&cli.StringFlag{
+			Name:  "log-level",
+			Usage: "Minimum severity of messages to print (debug, info, warn, error)",
+			Value: "info",
+		},
+		&cli.StringFlag{
+			Name:  "config",
+			Usage: "Read default flag values from this YAML or TOML file (default: .resizer.yaml in the working or home directory)",
 		},
 	}
+}
 
-	if err := app.Run(os.Args); err != nil {
-		safePrint(fmt.Sprintf("Error: %v", err))
+// inheritSharedFlags copies shared flags given before the command name onto
+// the command, unless the command line sets them again after it.
+func inheritSharedFlags(c *cli.Context) error {
+	lineage := c.Lineage()
+	for _, flag := range sharedFlags() {
+		name := flag.Names()[0]
+		if c.IsSet(name) {
+			continue
+		}
+		for _, parent := range lineage[1:] {
+			if parent.IsSet(name) {
+				if err := c.Set(name, parent.String(name)); err != nil {
+					return err
+				}
+				break
+			}
+		}
 	}
+	return nil
 }
 
-func processPath(path string, memoryLimit int64, outputDir string, algorithm resize.InterpolationFunction, quality int, dryRun, recursive bool, dpi int) {
+// withDefaultCommand inserts "resize" into args when no command is named, so
+// "resizer photos" keeps working as "resizer resize photos".
+func withDefaultCommand(args []string, app *cli.App) []string {
+	takesValue := map[string]bool{}
+	for _, flag := range app.Flags {
+		_, isBool := flag.(*altsrc.BoolFlag)
+		for _, name := range flag.Names() {
+			takesValue[name] = !isBool
+		}
+	}
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			if app.Command(arg) != nil || arg == "help" || arg == "h" {
+				return args
+			}
+			break
+		}
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if name == "help" || name == "h" {
+			return args
+		}
+		needsValue, ok := takesValue[name]
+		if !ok {
+			break
+		}
+		if needsValue && !hasValue {
+			i++
+		}
+	}
+	if len(args) < 2 {
+		return args
+	}
+	return slices.Insert(slices.Clone(args), 1, "resize")
+}
+
+// batchFile is an input file and the directory its output path mirrors.
+type batchFile struct {
+	path string
+	root string
+}
+
+// expandPath returns the files to process for a path given on the command
+// line: a file, a directory, a wildcard pattern, or a URL. Paths that cannot be
+// read are recorded as failures.
+func expandPath(path string, opts *options, summary *runSummary) []batchFile {
+	if isURL(path) {
+		return []batchFile{{path: path}}
+	}
+	if isGlobPattern(path) {
+		return expandGlob(path, opts, summary)
+	}
+
 	info, err := os.Stat(path)
 	if err != nil {
-		safePrint(fmt.Sprintf("Error accessing path: %v", err))
-		return
+		logError(fmt.Sprintf("Error accessing path: %v", err))
+		recordResult(path, resizer.Result{}, err, opts, summary)
+		return nil
+	}
+
+	if !info.IsDir() {
+		return []batchFile{{path: path, root: filepath.Dir(path)}}
+	}
+	var files []batchFile
+	for _, file := range collectFiles(path, opts) {
+		files = append(files, batchFile{path: file, root: path})
 	}
+	return files
+}
 
-	var files []string
+// isGlobPattern reports whether path contains glob metacharacters and does
+// not name an existing file, so literal names containing brackets still work.
+func isGlobPattern(path string) bool {
+	if !strings.ContainsAny(path, "*?[") {
+		return false
+	}
+	_, err := os.Stat(path)
+	return err != nil
+}
 
-	if info.IsDir() {
-		files = collectFiles(path, recursive)
-	} else {
-		files = []string{path}
+// expandGlob expands a pattern that the shell left alone, as Windows shells
+// do. Matching directories are expanded as if named on the command line;
+// matching files are saved directly in the output directory.
+func expandGlob(pattern string, opts *options, summary *runSummary) []batchFile {
+	matches, err := filepath.Glob(pattern)
+	if err == nil && len(matches) == 0 {
+		err = fmt.Errorf("no files match %s", pattern)
+	}
+	if err != nil {
+		logError(fmt.Sprintf("Error accessing path: %v", err))
+		recordResult(pattern, resizer.Result{}, err, opts, summary)
+		return nil
 	}
 
+	var files []batchFile
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && info.IsDir() {
+			files = append(files, expandPath(match, opts, summary)...)
+		} else {
+			files = append(files, batchFile{path: match, root: filepath.Dir(match)})
+		}
+	}
+	return files
+}
+
+// readFileList returns the newline-separated paths read from r, as given to
+// --files-from. Each entry is treated as a single file or URL whose output is
+// saved directly in the output directory.
+func readFileList(r io.Reader, opts *options, summary *runSummary) ([]batchFile, error) {
+	var files []batchFile
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		path := strings.TrimSpace(scanner.Text())
+		if path == "" {
+			continue
+		}
+		if isURL(path) {
+			files = append(files, batchFile{path: path})
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			logError(fmt.Sprintf("Error accessing path: %v", err))
+			recordResult(path, resizer.Result{}, err, opts, summary)
+			continue
+		}
+		if info.IsDir() {
+			logWarn(fmt.Sprintf("Skipping directory in file list: %s", path))
+			continue
+		}
+		files = append(files, batchFile{path: path, root: filepath.Dir(path)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read file list: %w", err)
+	}
+	return files, nil
+}
+
+// processBatch resizes files concurrently, with outputs mirroring each file's
+// path relative to its root. Once ctx is cancelled no new files are started.
+func processBatch(ctx context.Context, batch []batchFile, opts *options, summary *runSummary) {
+	var files []batchFile
+	for _, file := range batch {
+		if isURL(file.path) || isImageFile(file.path, opts) {
+			files = append(files, file)
+		}
+	}
+
+	if opts.sample > 0 && len(files) > opts.sample {
+		logInfo(fmt.Sprintf("Processing a random sample of %d of %d files (--sample with --seed %d)", opts.sample, len(files), opts.seed))
+		files = sampleFiles(files, opts.sample, opts.seed)
+	}
+	if opts.maxFiles > 0 && len(files) > opts.maxFiles {
+		logInfo(fmt.Sprintf("Limiting the run to the first %d of %d files (--max-files)", opts.maxFiles, len(files)))
+		files = files[:opts.maxFiles]
+	}
+
+	opts.urlNames = urlOutputNames(files)
+
 	// write that we are processing the files
-	safePrint(fmt.Sprintf("Processing %d files", len(files)))
-	bar := pb.StartNew(len(files))
+	logInfo(fmt.Sprintf("Processing %d files", len(files)))
+	progress := newBatchProgress(len(files))
 
 	var wg sync.WaitGroup
+	// Bounds how many files are decoded at the same time.
+	semaphore := make(chan struct{}, opts.concurrency)
 
 	for _, file := range files {
-		ext := strings.ToLower(filepath.Ext(file))
-		if isValidImageExtension(ext) {
-			wg.Add(1)
-			go func(file string) {
-				defer wg.Done()
-				processFile(file, memoryLimit, outputDir, algorithm, quality, dryRun, bar, dpi)
-			}(file)
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
 		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(file, root string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			result, err := processFileSafely(ctx, file, root, opts)
+			progress.fileDone(result.SourceBytes)
+			if errors.Is(err, context.Canceled) {
+				logInfo(fmt.Sprintf("Stopped before finishing %s", file))
+				return
+			}
+			if err != nil {
+				logError(fmt.Sprintf("Error processing %s: %v", file, err))
+			} else if opts.state != nil && !opts.DryRun {
+				opts.state.markCompleted(file)
+			}
+			recordResult(file, result, err, opts, summary)
+		}(file.path, file.root)
 	}
 
 	wg.Wait()
-	bar.Finish()
+	progress.finish()
 
 	flushMessages()
 }
 
-func processFile(filePath string, memoryLimit int64, outputDir string, algorithm resize.InterpolationFunction, quality int, dryRun bool, bar *pb.ProgressBar, overrideDPI int) {
-	defer bar.Increment()
+// sampleFiles picks n of files at random, the same ones for the same seed,
+// and returns them in their original order.
+func sampleFiles(files []batchFile, n int, seed int64) []batchFile {
+	picked := rand.New(rand.NewSource(seed)).Perm(len(files))[:n]
+	slices.Sort(picked)
+	sample := make([]batchFile, n)
+	for i, index := range picked {
+		sample[i] = files[index]
+	}
+	return sample
+}
+
+// recordResult adds the outcome for path to the summary, and to the --json
+// output and --manifest when they are enabled.
+func recordResult(path string, result resizer.Result, err error, opts *options, summary *runSummary) {
+	summary.record(path, result, err)
+	if opts.json {
+		printJSONResult(path, result, err)
+	}
+	if opts.manifest != nil {
+		opts.manifest.record(path, result, err)
+	}
+}
 
-	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
-		safePrint(fmt.Sprintf("Error creating output directory: %v", err))
-		return
+// processFileSafely calls processFile, turning a panic in a decoder or
+// encoder into an error so one corrupt file cannot end the whole run.
+func processFileSafely(ctx context.Context, filePath, root string, opts *options) (result resizer.Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unexpected failure: %v", r)
+		}
+	}()
+	return processFile(ctx, filePath, root, opts)
+}
+
+// processFile resizes a single file. Its output is placed under the output
+// directory at the same path relative to root as the source has, or with
+// --flatten directly in the output directory with that path in its name.
+func processFile(ctx context.Context, filePath, root string, opts *options) (resizer.Result, error) {
+	if opts.state != nil && opts.state.completed(filePath) {
+		logInfo(fmt.Sprintf("Skipping %s: already finished according to the state file", filePath))
+		return resizer.Result{Skipped: true}, nil
+	}
+	if isURL(filePath) {
+		return processURL(ctx, filePath, opts)
+	}
+
+	relDir, err := filepath.Rel(root, filepath.Dir(filePath))
+	if err != nil {
+		relDir = "."
 	}
+	namePrefix := ""
+	if opts.flatten && relDir != "." {
+		namePrefix = strings.ReplaceAll(filepath.ToSlash(relDir), "/", "_") + "_"
+		relDir = "."
+	}
+	outputDir := filepath.Join(opts.outputDir, relDir)
 
-	outputFileName := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath)) + "-resized" + filepath.Ext(filePath)
-	outputPath := filepath.Join(outputDir, outputFileName)
+	if !opts.DryRun && !opts.inPlace && opts.archive == nil && relDir != "." {
+		if err := ensureOutputSubdir(outputDir); err != nil {
+			return resizer.Result{}, err
+		}
+	}
 
-	if _, err := os.Stat(outputPath); err == nil {
-		safePrint(fmt.Sprintf("Skipping existing file: %s", outputPath))
-		return
+	format := ""
+	if opts.Format == "" {
+		if detected, err := resizer.DetectFormat(filePath); err == nil {
+			format = detected
+		}
+	}
+	if opts.inPlace && format != "" && resizer.OutputFormatFor(format) != format {
+		return resizer.Result{}, fmt.Errorf("cannot replace %s in place: %s images are saved as %s", filePath, strings.ToUpper(format), strings.ToUpper(resizer.OutputFormatFor(format)))
+	}
+	outputExt := outputExtension(filePath, format, opts)
+
+	outputPathFor := func(width, height, dpi int) string {
+		if opts.inPlace {
+			return filePath
+		}
+
+		outputFileName := namePrefix + expandNameTemplate(opts.nameTemplate, filePath, outputExt, width, height, dpi)
+		if opts.archive != nil {
+			name := filepath.ToSlash(filepath.Join(relDir, outputFileName))
+			if opts.archive.contains(name) {
+				logWarn(fmt.Sprintf("Skipping %s: the archive already has an entry named %s", filePath, name))
+				return ""
+			}
+			return name
+		}
+		outputPath := filepath.Join(outputDir, outputFileName)
+
+		if samePath(outputPath, filePath) {
+			logWarn(fmt.Sprintf("Skipping %s: the output would overwrite the source (use --in-place to replace sources)", filePath))
+			return ""
+		}
+		if _, err := os.Stat(outputPath); err == nil && !opts.overwrite {
+			logInfo(fmt.Sprintf("Skipping existing file: %s", outputPath))
+			return ""
+		}
+		return outputPath
+	}
+
+	// Templates without size placeholders can be checked before decoding.
+	if !opts.inPlace && !templateUsesDimensions(opts.nameTemplate) && outputPathFor(0, 0, 0) == "" {
+		return resizer.Result{Skipped: true}, nil
+	}
+
+	if opts.duplicates != nil {
+		original, err := opts.duplicates.claim(filePath)
+		if err != nil {
+			return resizer.Result{}, err
+		}
+		if original != "" {
+			logInfo(fmt.Sprintf("Skipping %s: identical to %s", filePath, original))
+			return resizer.Result{Skipped: true}, nil
+		}
+	}
+
+	if reason, err := belowMinimumSize(filePath, opts); err != nil {
+		return resizer.Result{}, err
+	} else if reason != "" {
+		logInfo(fmt.Sprintf("Skipping %s: %s", filePath, reason))
+		return resizer.Result{Skipped: true}, nil
 	}
 
 	var dpi int
-	if overrideDPI == 0 {
-		if extractedDPI, err := extractDPI(filePath); err == nil {
+	if opts.dpi == 0 {
+		if extractedDPI, err := resizer.ExtractDPI(filePath); err == nil {
 			dpi = extractedDPI
-			safePrint(fmt.Sprintf("Extracted DPI for %s: %d", filePath, dpi))
+			logDebug(fmt.Sprintf("Extracted DPI for %s: %d", filePath, dpi))
 		} else {
-			dpi = 72
-			safePrint(fmt.Sprintf("Failed to extract DPI for %s: %v", filePath, err))
+			logDebug(fmt.Sprintf("No DPI found in %s, assuming %d for sizing: %v", filePath, resizer.DefaultDPI, err))
 		}
 	} else {
-		dpi = overrideDPI
+		dpi = opts.dpi
 	}
 
-	safePrint(fmt.Sprintf("Processing %s", filePath))
+	logDebug(fmt.Sprintf("Processing %s", filePath))
 
-	if err := resizeImage(filePath, outputPath, dryRun, memoryLimit, algorithm, quality, dpi); err != nil {
-		safePrint(fmt.Sprintf("Error resizing image: %v", err))
+	start := time.Now()
+	result, err := resizeWithTimeout(ctx, opts, func(ctx context.Context) (resizer.Result, error) {
+		return resizeImage(ctx, filePath, outputPathFor, dpi, opts)
+	})
+	if err == nil && !result.Skipped {
+		noteQualityIgnored(result.Format, opts)
+		logDebug(fmt.Sprintf("Finished %s in %s", filePath, time.Since(start).Round(time.Millisecond)))
 	}
+	return result, err
 }
 
-func collectFiles(dir string, recursive bool) []string {
-	var files []string
+// outputExtension returns the extension for the output of name, an input
+// holding an image in format, or "" if its format could not be detected.
+func outputExtension(name, format string, opts *options) string {
+	outputExt := filepath.Ext(name)
+	if opts.Format != "" {
+		outputExt = resizer.FormatExtension(opts.Format)
+	} else {
+		// Name the output after what the file holds, which is not always
+		// what its extension says.
+		extFormat := extensionFormat(outputExt)
+		if format == "" {
+			format = extFormat
+			if format == "" {
+				format = opts.inputFormat
+			}
+		} else if extFormat != "" && format != extFormat {
+			logInfo(fmt.Sprintf("%s holds a %s image despite its %s extension", name, strings.ToUpper(format), outputExt))
+		}
+		outputFormat := resizer.OutputFormatFor(format)
+		if format == "webp" && outputFormat != "webp" {
+			webpFallbackNote.Do(func() {
+				logWarn("This build cannot write WebP, so WebP images are saved as PNG; rebuild with cgo enabled to keep them as WebP")
+			})
+		}
+		if outputFormat != "" && outputFormat != extFormat {
+			outputExt = resizer.FormatExtension(outputFormat)
+		}
+	}
+	if opts.canonicalExt {
+		if format := extensionFormat(outputExt); format != "" && format != "heic" {
+			outputExt = resizer.FormatExtension(format)
+		}
+	}
+	if opts.lowercaseExt {
+		outputExt = strings.ToLower(outputExt)
+	}
+	return outputExt
+}
+
+// resizeWithTimeout calls resize, giving up once --timeout has passed.
+// Decoders cannot be interrupted, so the call is cancelled and stops at its
+// next check rather than going on to write an output. It is still waited for,
+// so the worker does not take another file until it has returned and
+// --concurrency bounds the number of decodes in flight.
+func resizeWithTimeout(ctx context.Context, opts *options, resize func(context.Context) (resizer.Result, error)) (resizer.Result, error) {
+	if opts.timeout <= 0 {
+		return resize(ctx)
+	}
 
-	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	result, err := resize(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return resizer.Result{}, fmt.Errorf("gave up after %s (--timeout)", opts.timeout)
+	}
+	return result, err
+}
+
+// resizeImage calls ResizeImage, or adds the output to the --zip archive
+// instead of writing a file.
+func resizeImage(ctx context.Context, filePath string, outputPathFor resizer.OutputPathFunc, dpi int, opts *options) (resizer.Result, error) {
+	if opts.archive == nil {
+		return resizer.ResizeImage(ctx, filePath, outputPathFor, dpi, &opts.Options)
+	}
+
+	modified := time.Now()
+	if opts.PreserveModTime {
+		if info, err := os.Stat(filePath); err == nil {
+			modified = info.ModTime()
+		}
+	}
+	return resizer.ResizeImageTo(ctx, filePath, outputPathFor, dpi, &opts.Options, func(name string, encode func(w io.Writer) error) error {
+		return opts.archive.add(name, modified, encode)
+	})
+}
+
+// belowMinimumSize reports why a file falls under the --min-width,
+// --min-height, or --min-bytes thresholds, or "" if it does not. Only the file
+// size and image header are read, so small files are skipped without decoding.
+func belowMinimumSize(filePath string, opts *options) (string, error) {
+	if opts.minBytes > 0 {
+		info, err := os.Stat(filePath)
 		if err != nil {
-			return err
+			return "", fmt.Errorf("failed to stat file: %w", err)
 		}
+		if info.Size() < opts.minBytes {
+			return fmt.Sprintf("%d bytes is below the minimum of %d", info.Size(), opts.minBytes), nil
+		}
+	}
 
-		if !d.IsDir() {
-			ext := strings.ToLower(filepath.Ext(d.Name()))
-			if isValidImageExtension(ext) {
-				files = append(files, path)
-			}
+	if opts.minWidth <= 0 && opts.minHeight <= 0 {
+		return "", nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		// Leave unreadable files to ResizeImage, which explains what is wrong.
+		return "", nil
+	}
+
+	width, height := config.Width, config.Height
+	if opts.AutoOrient {
+		// Orientations 5-8 are stored sideways.
+		if orientation, err := resizer.ExtractOrientation(filePath); err == nil && orientation >= 5 {
+			width, height = height, width
 		}
+	}
+
+	if width < opts.minWidth || height < opts.minHeight {
+		return fmt.Sprintf("%dx%d is below the minimum size", width, height), nil
+	}
+	return "", nil
+}
+
+// expandNameTemplate builds an output file name from the source path, the
+// output extension, and the resized dimensions. {ext} includes the leading dot.
+func expandNameTemplate(template, filePath, ext string, width, height, dpi int) string {
+	replacer := strings.NewReplacer(
+		"{name}", strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath)),
+		"{ext}", ext,
+		"{width}", strconv.Itoa(width),
+		"{height}", strconv.Itoa(height),
+		"{dpi}", strconv.Itoa(dpi),
+	)
+	return replacer.Replace(template)
+}
 
-		if !recursive && d.IsDir() && path != dir {
-			return filepath.SkipDir
+// samePath reports whether a and b refer to the same location once made
+// absolute and cleaned.
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// outputNamePattern returns a regular expression matching file names that
+// template could have produced, or nil if the template has no literal text
+// to tell outputs apart from sources (such as "{name}{ext}").
+func outputNamePattern(template string) *regexp.Regexp {
+	placeholders := map[string]string{
+		"{name}":   `.+`,
+		"{ext}":    `\.[^.]+`,
+		"{width}":  `\d+`,
+		"{height}": `\d+`,
+		"{dpi}":    `\d+`,
+	}
+
+	var pattern strings.Builder
+	literal := false
+	for rest := template; rest != ""; {
+		matched := false
+		for placeholder, expr := range placeholders {
+			if strings.HasPrefix(rest, placeholder) {
+				pattern.WriteString(expr)
+				rest = rest[len(placeholder):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			pattern.WriteString(regexp.QuoteMeta(rest[:1]))
+			rest = rest[1:]
+			literal = true
 		}
+	}
+	if !literal || !strings.Contains(template, "{name}") {
 		return nil
-	})
+	}
+	return regexp.MustCompile("^" + pattern.String() + "$")
+}
 
-	return files
+func templateUsesDimensions(template string) bool {
+	return strings.Contains(template, "{width}") || strings.Contains(template, "{height}") || strings.Contains(template, "{dpi}")
+}
+
+// collectFiles lists the images in dir, descending into subdirectories when
+// --recursive is set. Excluded paths and files named like this tool's own
+// outputs are left out. Symlinked directories are only followed with
+// --follow-symlinks.
+func collectFiles(dir string, opts *options) []string {
+	w := &fileWalker{root: dir, opts: opts, visited: map[string]bool{}}
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		w.visited[real] = true
+	}
+	w.walk(dir)
+
+	if w.previousOutputs > 0 {
+		logInfo(fmt.Sprintf("Skipped %d files in %s whose names match %q, as they look like earlier outputs", w.previousOutputs, dir, opts.nameTemplate))
+	}
+
+	return w.files
+}
+
+// fileWalker collects files for collectFiles. visited holds the resolved
+// path of every directory entered, so a symlink back to one of them cannot
+// make the walk loop or scan the same files twice.
+type fileWalker struct {
+	root            string
+	opts            *options
+	visited         map[string]bool
+	files           []string
+	previousOutputs int
+	// ignores holds the .resizerignore rules of dir and every directory
+	// above it within the walk, outermost first.
+	ignores []ignoreRule
+}
+
+func (w *fileWalker) walk(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logWarn(fmt.Sprintf("Failed to read directory %s: %v", dir, err))
+		return
+	}
+
+	if !w.opts.noIgnoreFile {
+		rules, err := readIgnoreFile(dir)
+		if err != nil {
+			logWarn(fmt.Sprintf("Not applying an ignore file: %v", err))
+		}
+		if len(rules) > 0 {
+			parent := w.ignores
+			w.ignores = append(parent[:len(parent):len(parent)], rules...)
+			defer func() { w.ignores = parent }()
+		}
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		isDir := entry.IsDir()
+
+		if entry.Type()&os.ModeSymlink != 0 {
+			info, err := os.Stat(path)
+			if err != nil {
+				logWarn(fmt.Sprintf("Skipping broken symlink %s: %v", path, err))
+				continue
+			}
+			if info.IsDir() {
+				if !w.opts.recursive {
+					continue
+				}
+				if !w.opts.followSymlinks {
+					logWarn(fmt.Sprintf("Skipping symlinked directory %s (use --follow-symlinks to include it)", path))
+					continue
+				}
+				isDir = true
+			}
+		}
+
+		rel, _ := filepath.Rel(w.root, path)
+		if isExcluded(rel, isDir, w.opts.exclude) {
+			logDebug(fmt.Sprintf("Excluding %s", path))
+			continue
+		}
+		if isIgnored(path, isDir, w.ignores) {
+			logDebug(fmt.Sprintf("Ignoring %s (%s)", path, ignoreFileName))
+			continue
+		}
+
+		if isDir {
+			if !w.opts.recursive {
+				continue
+			}
+			real, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				logWarn(fmt.Sprintf("Failed to resolve %s: %v", path, err))
+				continue
+			}
+			if w.visited[real] {
+				logWarn(fmt.Sprintf("Skipping %s: it leads to a directory that was already scanned", path))
+				continue
+			}
+			w.visited[real] = true
+			w.walk(path)
+			continue
+		}
+
+		if !isImageFile(entry.Name(), w.opts) {
+			continue
+		}
+		if w.opts.outputPattern != nil && w.opts.outputPattern.MatchString(entry.Name()) {
+			logDebug(fmt.Sprintf("Skipping %s: it looks like an earlier output", path))
+			w.previousOutputs++
+			continue
+		}
+		w.files = append(w.files, path)
+	}
+}
+
+// isExcluded reports whether rel, a path relative to the directory being
+// walked, matches any --exclude glob. Patterns without a slash match the base
+// name at any depth; patterns with one match the whole relative path. A
+// trailing slash restricts a pattern to directories.
+func isExcluded(rel string, isDir bool, patterns []string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, pattern := range patterns {
+		pattern = filepath.ToSlash(pattern)
+		if strings.HasSuffix(pattern, "/") {
+			if !isDir {
+				continue
+			}
+			pattern = strings.TrimSuffix(pattern, "/")
+		}
+
+		target := path.Base(rel)
+		if strings.Contains(pattern, "/") {
+			target = rel
+		}
+		if matched, _ := path.Match(pattern, target); matched {
+			return true
+		}
+	}
+	return false
 }
 
 func isValidImageExtension(ext string) bool {
-	return ext == ".jpg" || ext == ".jpeg" || ext == ".png"
+	return ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".webp" || ext == ".gif" || ext == ".tif" || ext == ".tiff" || ext == ".bmp" || ext == ".heic" || ext == ".heif"
 }
 
-func getResizeAlgorithm(name string) resize.InterpolationFunction {
+// isImageFile reports whether path should be processed: it has an image
+// extension, or no extension at all when --input-format is given.
+func isImageFile(path string, opts *options) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == "" {
+		return opts.inputFormat != ""
+	}
+	return isValidImageExtension(ext)
+}
+
+// extensionFormat returns the format an image extension stands for, or "" for
+// extensions that name no readable format.
+func extensionFormat(ext string) string {
+	switch strings.ToLower(ext) {
+	case ".heic", ".heif":
+		return "heic"
+	}
+	if !isValidImageExtension(strings.ToLower(ext)) {
+		return ""
+	}
+	format, err := resizer.NormalizeFormat(ext)
+	if err != nil {
+		return ""
+	}
+	return format
+}
+
+// normalizeInputFormat checks an --input-format value, accepting the same
+// aliases as --format plus heic and heif.
+func normalizeInputFormat(name string) (string, error) {
 	switch strings.ToLower(name) {
-	case "bilinear":
-		return resize.Bilinear
-	case "nearest":
-		return resize.NearestNeighbor
+	case "heic", "heif":
+		return "heic", nil
+	case "avif":
+		return "", fmt.Errorf("AVIF files cannot be read yet")
+	}
+	format, err := resizer.NormalizeFormat(name)
+	if err != nil {
+		return "", fmt.Errorf("unsupported input format %q (valid formats: jpeg, png, webp, gif, tiff, bmp, heic)", name)
+	}
+	return format, nil
+}
+
+func getPNGCompression(name string) (png.CompressionLevel, error) {
+	switch strings.ToLower(name) {
+	case "default":
+		return png.DefaultCompression, nil
+	case "none":
+		return png.NoCompression, nil
+	case "speed":
+		return png.BestSpeed, nil
+	case "best":
+		return png.BestCompression, nil
+	default:
+		return 0, fmt.Errorf("unknown PNG compression %q (valid values: default, none, speed, best)", name)
+	}
+}
+
+func getTIFFCompression(name string) (tiff.CompressionType, error) {
+	switch strings.ToLower(name) {
+	case "deflate":
+		return tiff.Deflate, nil
+	case "none":
+		return tiff.Uncompressed, nil
 	default:
-		return resize.Lanczos3
+		return 0, fmt.Errorf("unknown TIFF compression %q (valid values: deflate, none)", name)
+	}
+}
+
+// parseScale accepts a factor such as "0.5" or a percentage such as "50%".
+func parseScale(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	divisor := 1.0
+	if strings.HasSuffix(value, "%") {
+		value = strings.TrimSuffix(value, "%")
+		divisor = 100
+	}
+
+	scale, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid scale %q: %w", value, err)
+	}
+	scale /= divisor
+	if scale <= 0 {
+		return 0, fmt.Errorf("scale must be greater than zero")
+	}
+	return scale, nil
+}
+
+// parseByteSize accepts a byte count such as "204800" or a size with a
+// binary unit such as "200KB" or "1.5MB".
+func parseByteSize(value string) (int64, error) {
+	number := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := 1.0
+	for i, unit := range []string{"KB", "MB", "GB"} {
+		if strings.HasSuffix(number, unit) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, unit))
+			multiplier = math.Pow(1024, float64(i+1))
+			break
+		}
+	}
+	number = strings.TrimSuffix(number, "B")
+
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid size %q: expected a positive number of bytes, optionally with KB, MB or GB", value)
+	}
+	return int64(size * multiplier), nil
+}
+
+// parseByteLimit is parseByteSize for limits, where 0 with or without a unit,
+// such as "0MB", means no limit and is returned as 0.
+func parseByteLimit(value string) (int64, error) {
+	size, err := parseByteSize(value)
+	if err == nil {
+		return size, nil
+	}
+	number := strings.TrimRight(strings.ToUpper(strings.TrimSpace(value)), "KMGB ")
+	if zero, parseErr := strconv.ParseFloat(number, 64); parseErr == nil && zero == 0 {
+		return 0, nil
+	}
+	return 0, fmt.Errorf("invalid limit %q: expected a number of bytes, optionally with KB, MB or GB, or 0 for no limit", value)
+}
+
+// defaultQuality applies to formats --quality does not name.
+const defaultQuality = 75
+
+// parseQuality reads --quality: a number for every format, per-format values
+// such as "jpeg=85,webp=80", or both, as in "80,jpeg=90". Formats that are not
+// named use the plain number, or defaultQuality.
+func parseQuality(value string) (int, map[string]int, error) {
+	quality := defaultQuality
+	var perFormat map[string]int
+	for _, part := range strings.Split(value, ",") {
+		name, number, named := strings.Cut(strings.TrimSpace(part), "=")
+		if !named {
+			number = name
+		}
+		q, err := strconv.Atoi(strings.TrimSpace(number))
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid quality %q: expected a number or format=number pairs such as jpeg=85,webp=80", value)
+		}
+		if q < 1 || q > 100 {
+			return 0, nil, fmt.Errorf("invalid quality %d (must be between 1 and 100)", q)
+		}
+		if !named {
+			quality = q
+			continue
+		}
+
+		format, err := resizer.NormalizeFormat(strings.TrimSpace(name))
+		if err != nil {
+			return 0, nil, err
+		}
+		if format != "jpeg" && format != "webp" && format != "avif" {
+			return 0, nil, fmt.Errorf("invalid quality %q: %s has no quality setting (valid formats: jpeg, webp, avif)", value, format)
+		}
+		if perFormat == nil {
+			perFormat = map[string]int{}
+		}
+		perFormat[format] = q
+	}
+	return quality, perFormat, nil
+}
+
+// parseHexColor parses colors written as #rgb, #rrggbb or #rrggbbaa; the #
+// is optional.
+func parseHexColor(value string) (color.Color, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(value), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return nil, fmt.Errorf("invalid color %q: expected #rgb, #rrggbb or #rrggbbaa", value)
+	}
+
+	rgba, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid color %q: %w", value, err)
+	}
+	return color.NRGBA{R: uint8(rgba >> 24), G: uint8(rgba >> 16), B: uint8(rgba >> 8), A: uint8(rgba)}, nil
+}
+
+// loadWatermark decodes the watermark image once so every worker can share it.
+func loadWatermark(path, position string, opacity float64) (*resizer.Watermark, error) {
+	if !resizer.ValidOverlayPosition(position) {
+		return nil, fmt.Errorf("invalid watermark position %q (valid values: %s)", position, strings.Join(resizer.OverlayPositions, ", "))
+	}
+	if opacity < 0 || opacity > 1 {
+		return nil, fmt.Errorf("invalid watermark opacity %v (must be between 0 and 1)", opacity)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open watermark: %w", err)
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode watermark %s: %w", path, err)
+	}
+	return &resizer.Watermark{Image: img, Position: position, Opacity: opacity}, nil
+}
+
+// parseCaption builds the caption drawn by --text from its flags.
+func parseCaption(text, position, textColor, background string) (*resizer.Caption, error) {
+	if !resizer.ValidOverlayPosition(position) {
+		return nil, fmt.Errorf("invalid text position %q (valid values: %s)", position, strings.Join(resizer.OverlayPositions, ", "))
+	}
+	caption := &resizer.Caption{Text: text, Position: position}
+
+	var err error
+	if caption.Color, err = parseHexColor(textColor); err != nil {
+		return nil, err
+	}
+	if background != "" {
+		if caption.Background, err = parseHexColor(background); err != nil {
+			return nil, err
+		}
+	}
+	return caption, nil
+}
+
+// parseAspectRatio parses a ratio written as "width:height", such as "16:9".
+func parseAspectRatio(value string) (float64, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid aspect ratio %q: expected width:height", value)
+	}
+
+	width, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid aspect ratio %q: %w", value, err)
+	}
+	height, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid aspect ratio %q: %w", value, err)
+	}
+	if width <= 0 || height <= 0 {
+		return 0, fmt.Errorf("invalid aspect ratio %q: both sides must be positive", value)
 	}
+	return width / height, nil
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParseQuality(t *testing.T) {
+	tests := []struct {
+		value     string
+		want      int
+		perFormat map[string]int
+		wantErr   bool
+	}{
+		{"75", 75, nil, false},
+		{" 90 ", 90, nil, false},
+		{"jpeg=85,webp=80", defaultQuality, map[string]int{"jpeg": 85, "webp": 80}, false},
+		{"80, jpg=90", 80, map[string]int{"jpeg": 90}, false},
+		{"avif=40,60", 60, map[string]int{"avif": 40}, false},
+		{"0", 0, nil, true},
+		{"101", 0, nil, true},
+		{"high", 0, nil, true},
+		{"jpeg=", 0, nil, true},
+		{"png=80", 0, nil, true},
+		{"bogus=80", 0, nil, true},
+	}
+	for _, tt := range tests {
+		got, perFormat, err := parseQuality(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: error %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want || !maps.Equal(perFormat, tt.perFormat) {
+			t.Errorf("%q: got %d %v, want %d %v", tt.value, got, perFormat, tt.want, tt.perFormat)
+		}
+	}
+}
+
+func TestParseByteLimit(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{"1024", 1024, false},
+		{"2KB", 2048, false},
+		{"1.5MB", 1536 * 1024, false},
+		{"0", 0, false},
+		{"0MB", 0, false},
+		{"0 kb", 0, false},
+		{"-1MB", 0, true},
+		{"MB", 0, true},
+		{"lots", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseByteLimit(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: error %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
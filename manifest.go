@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"restore/pkg/resizer"
+)
+
+// manifestHeader names the columns of a --manifest file.
+var manifestHeader = []string{
+	"source", "output", "original_width", "original_height", "new_width", "new_height",
+	"source_bytes", "output_bytes", "dpi", "algorithm", "status", "error",
+}
+
+// csvManifest records one row per file for --manifest. Rows are appended to
+// any existing file, so the manifests of several runs can share one file, and
+// each row is flushed as soon as it is written so a run that is killed still
+// leaves a usable record.
+type csvManifest struct {
+	algorithm string
+	dryRun    bool
+
+	mu     sync.Mutex
+	file   *os.File
+	writer *csv.Writer
+	failed bool
+}
+
+// openManifest opens path for appending, writing the header row if the file
+// is new or empty.
+func openManifest(path, algorithm string, dryRun bool) (*csvManifest, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+
+	m := &csvManifest{algorithm: algorithm, dryRun: dryRun, file: file, writer: csv.NewWriter(file)}
+	if info.Size() == 0 {
+		m.writer.Write(manifestHeader)
+		m.writer.Flush()
+		if err := m.writer.Error(); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to write manifest: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// record appends the row for one file. A write error is reported once and
+// later rows are dropped rather than failing the files they describe.
+func (m *csvManifest) record(path string, result resizer.Result, err error) {
+	status, message := "resized", ""
+	switch {
+	case err != nil:
+		status, message = "failed", err.Error()
+	case result.Larger:
+		status = "larger"
+	case result.Skipped:
+		status = "skipped"
+	case m.dryRun:
+		status = "dry-run"
+	}
+
+	row := []string{
+		path,
+		result.OutputPath,
+		optionalInt(int64(result.OriginalW)),
+		optionalInt(int64(result.OriginalH)),
+		optionalInt(int64(result.NewW)),
+		optionalInt(int64(result.NewH)),
+		optionalInt(result.SourceBytes),
+		optionalInt(result.OutputBytes),
+		optionalInt(int64(result.DPI)),
+		m.algorithm,
+		status,
+		message,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failed {
+		return
+	}
+	m.writer.Write(row)
+	m.writer.Flush()
+	if err := m.writer.Error(); err != nil {
+		m.failed = true
+		logError(fmt.Sprintf("Failed to write manifest, no further rows will be recorded: %v", err))
+	}
+}
+
+// close flushes and closes the manifest file.
+func (m *csvManifest) close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writer.Flush()
+	err := m.writer.Error()
+	if closeErr := m.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// optionalInt formats n, leaving the cell empty when it is zero.
+func optionalInt(n int64) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.FormatInt(n, 10)
+}
@@ -0,0 +1,83 @@
+//go:build avif && cgo
+
+package resizer
+
+/*
+#cgo pkg-config: libavif
+#include <avif/avif.h>
+
+// encodeAVIF encodes an 8-bit RGBA buffer. On success *out holds the
+// encoded file, which the caller frees with avifRWDataFree.
+static avifResult encodeAVIF(uint8_t *pixels, uint32_t width, uint32_t height, uint32_t stride, int quantizer, avifRWData *out) {
+	avifImage *image = avifImageCreate(width, height, 8, AVIF_PIXEL_FORMAT_YUV420);
+	if (image == NULL) {
+		return AVIF_RESULT_OUT_OF_MEMORY;
+	}
+
+	avifRGBImage rgb;
+	avifRGBImageSetDefaults(&rgb, image);
+	rgb.format = AVIF_RGB_FORMAT_RGBA;
+	rgb.depth = 8;
+	rgb.pixels = pixels;
+	rgb.rowBytes = stride;
+
+	avifResult result = avifImageRGBToYUV(image, &rgb);
+	if (result != AVIF_RESULT_OK) {
+		avifImageDestroy(image);
+		return result;
+	}
+
+	avifEncoder *encoder = avifEncoderCreate();
+	if (encoder == NULL) {
+		avifImageDestroy(image);
+		return AVIF_RESULT_OUT_OF_MEMORY;
+	}
+	encoder->minQuantizer = quantizer;
+	encoder->maxQuantizer = quantizer;
+	encoder->minQuantizerAlpha = quantizer;
+	encoder->maxQuantizerAlpha = quantizer;
+	encoder->speed = 6;
+
+	result = avifEncoderWrite(encoder, image, out);
+	avifEncoderDestroy(encoder);
+	avifImageDestroy(image);
+	return result;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"unsafe"
+)
+
+// AVIFEncoding reports whether this build can write AVIF.
+const AVIFEncoding = true
+
+// encodeAVIF wraps libavif. quality (1-100) is mapped onto the AV1
+// quantizer, where 0 is lossless and 63 is the coarsest.
+func encodeAVIF(w io.Writer, img image.Image, quality int) error {
+	bounds := img.Bounds()
+	rgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	if len(rgba.Pix) == 0 {
+		return fmt.Errorf("cannot encode an empty image")
+	}
+
+	quantizer := C.int((100 - quality) * 63 / 99)
+
+	// libavif only reads the pixels during the call, so the Go buffer can
+	// be passed directly.
+	var out C.avifRWData
+	result := C.encodeAVIF((*C.uint8_t)(unsafe.Pointer(&rgba.Pix[0])), C.uint32_t(rgba.Rect.Dx()), C.uint32_t(rgba.Rect.Dy()), C.uint32_t(rgba.Stride), quantizer, &out)
+	if result != C.AVIF_RESULT_OK {
+		return fmt.Errorf("libavif: %s", C.GoString(C.avifResultToString(result)))
+	}
+	defer C.avifRWDataFree(&out)
+
+	_, err := w.Write(C.GoBytes(unsafe.Pointer(out.data), C.int(out.size)))
+	return err
+}
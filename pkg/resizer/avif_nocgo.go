@@ -0,0 +1,17 @@
+//go:build !avif || !cgo
+
+package resizer
+
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+// AVIFEncoding reports whether this build can write AVIF. The encoder wraps
+// libavif, so it is only built with the avif tag in cgo builds.
+const AVIFEncoding = false
+
+func encodeAVIF(w io.Writer, img image.Image, quality int) error {
+	return errors.New("AVIF encoding requires a cgo build with the avif tag and libavif installed")
+}
@@ -0,0 +1,67 @@
+package resizer
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryBudget caps the number of bytes of decoded image data held by all
+// concurrent ResizeImage calls sharing it. A nil budget places no limit.
+type MemoryBudget struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int64
+	inUse int64
+}
+
+// NewMemoryBudget returns a budget allowing limit bytes to be in use at once.
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	b := &MemoryBudget{limit: limit}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire blocks until n bytes fit within the budget and reserves them, or
+// until ctx is done, in which case it reserves nothing and returns ctx.Err().
+// A request larger than the whole budget waits until nothing else is in
+// flight. It returns the amount actually reserved, which must be passed to
+// release.
+func (b *MemoryBudget) acquire(ctx context.Context, n int64) (int64, error) {
+	if b == nil {
+		return 0, nil
+	}
+	if n > b.limit {
+		n = b.limit
+	}
+
+	// Wake the waiters when ctx is done so this one can give up. Taking the
+	// lock first keeps the wakeup from slipping in before Wait.
+	stop := context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.cond.Broadcast()
+	})
+	defer stop()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.inUse+n > b.limit {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		b.cond.Wait()
+	}
+	b.inUse += n
+	return n, nil
+}
+
+func (b *MemoryBudget) release(n int64) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.inUse -= n
+	b.cond.Broadcast()
+}
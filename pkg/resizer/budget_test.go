@@ -0,0 +1,38 @@
+package resizer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryBudgetAcquireCanceled(t *testing.T) {
+	budget := NewMemoryBudget(100)
+	held, err := budget.acquire(context.Background(), 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := budget.acquire(ctx, 50)
+		done <- err
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("acquire returned %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("acquire kept waiting after its context was canceled")
+	}
+
+	// The canceled request must not have reserved anything.
+	budget.release(held)
+	if n, err := budget.acquire(context.Background(), 100); err != nil || n != 100 {
+		t.Errorf("acquire(100) = %d, %v after releasing everything", n, err)
+	}
+}
@@ -0,0 +1,101 @@
+package resizer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+const markerAPP0 = 0xE0
+
+// jfifSegment returns a JFIF APP0 segment recording dpi as the pixel
+// density, which print software reads to size a JPEG.
+func jfifSegment(dpi int) jpegSegment {
+	density := uint16(min(dpi, 0xFFFF))
+	payload := []byte("JFIF\x00\x01\x01\x01\x00\x00\x00\x00\x00\x00")
+	// Version 1.01, density in dots per inch, and no thumbnail.
+	binary.BigEndian.PutUint16(payload[8:], density)
+	binary.BigEndian.PutUint16(payload[10:], density)
+	return jpegSegment{marker: markerAPP0, payload: payload}
+}
+
+// physLength is the size of a pHYs chunk: two 4-byte densities and a unit.
+// Longer chunks are refused before anything is allocated, as the DPI is read
+// ahead of the pixel limits.
+const physLength = 9
+
+// physChunk returns a PNG pHYs chunk recording dpi. PNG stores density in
+// pixels per meter.
+func physChunk(dpi int) pngChunk {
+	ppm := uint32(math.Round(float64(dpi) / 0.0254))
+	data := make([]byte, physLength)
+	binary.BigEndian.PutUint32(data[0:], ppm)
+	binary.BigEndian.PutUint32(data[4:], ppm)
+	data[8] = 1 // unit is the meter
+	return pngChunk{chunkType: "pHYs", data: data}
+}
+
+// readPNGDPI returns the horizontal resolution in a PNG pHYs chunk,
+// converted from pixels per meter to dots per inch.
+func readPNGDPI(src io.Reader) (int, error) {
+	data, err := findPNGChunk(src, "pHYs", physLength)
+	if err != nil {
+		return 0, err
+	}
+	if data == nil {
+		return 0, errors.New("no pHYs chunk")
+	}
+	if len(data) != physLength {
+		return 0, errors.New("malformed pHYs chunk")
+	}
+	// Unit 0 records only the pixel aspect ratio, not a physical size.
+	if data[8] != 1 {
+		return 0, errors.New("pHYs chunk has no physical unit")
+	}
+
+	ppm := binary.BigEndian.Uint32(data[0:])
+	dpi := int(math.Round(float64(ppm) * 0.0254))
+	if dpi <= 0 {
+		return 0, errors.New("pHYs chunk records no resolution")
+	}
+	return dpi, nil
+}
+
+// readJFIFDPI returns the horizontal density in a JPEG's JFIF APP0 segment,
+// converted to dots per inch.
+func readJFIFDPI(src io.Reader) (int, error) {
+	var payload []byte
+	err := scanJPEGSegments(src, func(marker byte, data []byte) bool {
+		if marker != markerAPP0 || !bytes.HasPrefix(data, []byte("JFIF\x00")) {
+			return true
+		}
+		payload = data
+		return false
+	})
+	if err != nil {
+		return 0, err
+	}
+	if payload == nil {
+		return 0, errors.New("no JFIF segment")
+	}
+	if len(payload) < 12 {
+		return 0, errors.New("malformed JFIF segment")
+	}
+
+	density := float64(binary.BigEndian.Uint16(payload[8:]))
+	switch payload[7] {
+	case 1: // dots per inch
+	case 2: // dots per centimeter
+		density *= 2.54
+	default:
+		// Unit 0 records only the pixel aspect ratio.
+		return 0, errors.New("JFIF segment has no physical unit")
+	}
+	dpi := int(math.Round(density))
+	if dpi <= 0 {
+		return 0, errors.New("JFIF segment records no resolution")
+	}
+	return dpi, nil
+}
@@ -0,0 +1,130 @@
+package resizer
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/nfnt/resize"
+)
+
+func solidImage(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = 40, 120, 200, 255
+	}
+	return img
+}
+
+func TestDensityRoundTrip(t *testing.T) {
+	for _, format := range []string{"jpeg", "png"} {
+		for _, dpi := range []int{72, 96, 300, 600} {
+			var buf bytes.Buffer
+			if err := EncodeImage(&buf, solidImage(16, 16), format, Metadata{DPI: dpi}, &Options{Quality: 90}); err != nil {
+				t.Fatalf("%s at %d DPI: encode: %v", format, dpi, err)
+			}
+			got, err := ExtractDPIFrom(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("%s at %d DPI: extract: %v", format, dpi, err)
+			}
+			if got != dpi {
+				t.Errorf("%s: recorded %d DPI, read back %d", format, dpi, got)
+			}
+		}
+	}
+}
+
+func TestDensityZeroRecordsNothing(t *testing.T) {
+	for _, format := range []string{"jpeg", "png"} {
+		var buf bytes.Buffer
+		if err := EncodeImage(&buf, solidImage(16, 16), format, Metadata{}, &Options{Quality: 90}); err != nil {
+			t.Fatalf("%s: encode: %v", format, err)
+		}
+		if dpi, err := ExtractDPIFrom(bytes.NewReader(buf.Bytes())); err == nil {
+			t.Errorf("%s: expected no density, read %d DPI", format, dpi)
+		}
+	}
+}
+
+func TestResizeRecordsDPIOnlyWhenKnown(t *testing.T) {
+	var src bytes.Buffer
+	if err := png.Encode(&src, solidImage(800, 600)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		maxWidth  int
+		sourceDPI int
+		targetDPI int
+		want      int
+	}{
+		{"unknown source", 200, 0, 0, 0},
+		{"known source", 200, 300, 0, 75},
+		{"target DPI without a source DPI", 0, 0, 36, 36},
+	}
+	for _, tt := range tests {
+		opts := &Options{MaxWidth: tt.maxWidth, TargetDPI: tt.targetDPI, Algorithm: resize.Bilinear}
+		var out bytes.Buffer
+		result, err := Resize(context.Background(), bytes.NewReader(src.Bytes()), &out, tt.sourceDPI, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if result.DPI != tt.want {
+			t.Errorf("%s: Result.DPI = %d, want %d", tt.name, result.DPI, tt.want)
+		}
+		got, err := ExtractDPIFrom(bytes.NewReader(out.Bytes()))
+		if tt.want == 0 {
+			if err == nil {
+				t.Errorf("%s: expected no density in the output, read %d DPI", tt.name, got)
+			}
+		} else if got != tt.want {
+			t.Errorf("%s: output records %d DPI, want %d (err %v)", tt.name, got, tt.want, err)
+		}
+	}
+}
+
+func TestJFIFDensityInCentimeters(t *testing.T) {
+	segment := jfifSegment(0)
+	segment.payload[7] = 2 // dots per centimeter
+	segment.payload[9] = 118
+	var buf bytes.Buffer
+	buf.Write([]byte{0xFF, markerSOI, 0xFF, markerAPP0, 0, byte(len(segment.payload) + 2)})
+	buf.Write(segment.payload)
+	buf.Write([]byte{0xFF, 0xD9})
+
+	got, err := readJFIFDPI(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 118 dots per centimeter is 299.72 per inch.
+	if got != 300 {
+		t.Errorf("read %d DPI, want 300", got)
+	}
+}
+
+func TestPNGDPIWithOversizedChunks(t *testing.T) {
+	phys := physChunk(300).data
+	tests := []struct {
+		name   string
+		chunks [][]byte
+		want   int
+	}{
+		{"pHYs after a large chunk", [][]byte{rawPNGChunk("zTXt", 1<<20, make([]byte, 1<<20)), rawPNGChunk("pHYs", physLength, phys)}, 300},
+		{"skipped chunk claims 4 GB", [][]byte{rawPNGChunk("zTXt", 0xFFFFFFF0, nil)}, 0},
+		{"pHYs claims 4 GB", [][]byte{rawPNGChunk("pHYs", 0xFFFFFFF0, phys)}, 0},
+	}
+	for _, tt := range tests {
+		src := bytes.Join(append([][]byte{pngSignature}, tt.chunks...), nil)
+		got, err := ExtractDPIFrom(bytes.NewReader(src))
+		if tt.want == 0 {
+			if err == nil {
+				t.Errorf("%s: read %d DPI, want an error", tt.name, got)
+			}
+		} else if got != tt.want {
+			t.Errorf("%s: read %d DPI (error %v), want %d", tt.name, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package resizer
+
+import "math"
+
+// estimateOutputBytes guesses the encoded size of a width x height image in
+// format, for dry runs. When the format is unchanged the source's own
+// compression is the best guide, so its size is scaled by the pixel count.
+// Otherwise a rough bits-per-pixel figure for the format is used.
+func estimateOutputBytes(width, height int, format string, sourceBytes int64, sourcePixels int64, sourceFormat string, bytesPerPixel int, opts *Options) int64 {
+	pixels := float64(width) * float64(height)
+	if format == sourceFormat && sourceBytes > 0 && sourcePixels > 0 {
+		return int64(float64(sourceBytes) * pixels / float64(sourcePixels))
+	}
+
+	var bitsPerPixel float64
+	switch format {
+	case "jpeg":
+		// Photographs take about 2 bits per pixel at quality 75, rising
+		// steeply towards quality 100.
+		bitsPerPixel = 0.5 + 3.5*math.Pow(float64(opts.qualityFor(format))/100, 3)
+	case "webp":
+		if opts.Lossless {
+			bitsPerPixel = float64(bytesPerPixel*8) * 0.4
+		} else {
+			bitsPerPixel = 0.7 * (0.5 + 3.5*math.Pow(float64(opts.qualityFor(format))/100, 3))
+		}
+	case "avif":
+		bitsPerPixel = 0.5 * (0.5 + 3.5*math.Pow(float64(opts.qualityFor(format))/100, 3))
+	case "png":
+		bitsPerPixel = float64(bytesPerPixel*8) * 0.5
+	case "gif":
+		bitsPerPixel = 4
+	case "tiff":
+		bitsPerPixel = float64(bytesPerPixel * 8)
+		if opts.TIFFCompression != 0 {
+			bitsPerPixel *= 0.6
+		}
+	default:
+		bitsPerPixel = float64(bytesPerPixel * 8)
+	}
+	return int64(pixels * bitsPerPixel / 8)
+}
@@ -0,0 +1,174 @@
+package resizer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	markerSOI  = 0xD8
+	markerSOS  = 0xDA
+	markerAPP1 = 0xE1
+	markerAPP2 = 0xE2
+
+	tagOrientation    = 0x0112
+	tagXResolution    = 0x011A
+	tagYResolution    = 0x011B
+	tagResolutionUnit = 0x0128
+
+	typeShort    = 3
+	typeRational = 5
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// scanJPEGSegments calls fn with the marker and payload of each segment
+// before the image data of a JPEG stream, stopping early when fn returns
+// false. A stream that ends before the image data is not an error.
+func scanJPEGSegments(src io.Reader, fn func(marker byte, payload []byte) bool) error {
+	r := bufio.NewReader(src)
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil {
+		return fmt.Errorf("failed to read JPEG header: %w", err)
+	}
+	if soi[0] != 0xFF || soi[1] != markerSOI {
+		return errors.New("not a JPEG file")
+	}
+
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:2]); err != nil {
+			return nil
+		}
+		if header[0] != 0xFF {
+			return errors.New("malformed JPEG marker")
+		}
+		marker := header[1]
+		if marker == 0xFF {
+			// Fill bytes may pad markers; step forward one byte and try again.
+			if err := r.UnreadByte(); err != nil {
+				return err
+			}
+			continue
+		}
+		if marker == markerSOS {
+			return nil
+		}
+		if _, err := io.ReadFull(r, header[2:]); err != nil {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(header[2:]))
+		if length < 2 {
+			return errors.New("malformed JPEG segment length")
+		}
+		payload := make([]byte, length-2)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil
+		}
+		if !fn(marker, payload) {
+			return nil
+		}
+	}
+}
+
+// readEXIFSegment returns the raw payload of the first EXIF APP1 segment in a
+// JPEG stream, or nil if it has none.
+func readEXIFSegment(src io.Reader) ([]byte, error) {
+	var exifData []byte
+	err := scanJPEGSegments(src, func(marker byte, payload []byte) bool {
+		if marker == markerAPP1 && bytes.HasPrefix(payload, exifHeader) {
+			exifData = payload
+			return false
+		}
+		return true
+	})
+	return exifData, err
+}
+
+// patchEXIF rewrites the orientation and resolution tags of IFD0 in place.
+// An orientation or DPI of zero leaves the corresponding tags untouched.
+func patchEXIF(payload []byte, orientation, dpi int) error {
+	tiffData := payload[len(exifHeader):]
+	if len(tiffData) < 8 {
+		return errors.New("EXIF data too short")
+	}
+
+	var order binary.ByteOrder
+	switch string(tiffData[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return errors.New("invalid EXIF byte order")
+	}
+
+	ifd := int(order.Uint32(tiffData[4:8]))
+	if ifd+2 > len(tiffData) {
+		return errors.New("EXIF IFD0 offset out of range")
+	}
+	count := int(order.Uint16(tiffData[ifd:]))
+
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiffData) {
+			return errors.New("EXIF IFD0 entry out of range")
+		}
+		tag := order.Uint16(tiffData[entry:])
+		typ := order.Uint16(tiffData[entry+2:])
+		value := tiffData[entry+8 : entry+12]
+
+		switch {
+		case tag == tagOrientation && typ == typeShort && orientation != 0:
+			order.PutUint16(value, uint16(orientation))
+		case tag == tagResolutionUnit && typ == typeShort && dpi != 0:
+			order.PutUint16(value, 2) // inches
+		case (tag == tagXResolution || tag == tagYResolution) && typ == typeRational && dpi != 0:
+			offset := int(order.Uint32(value))
+			if offset+8 > len(tiffData) {
+				return errors.New("EXIF resolution offset out of range")
+			}
+			order.PutUint32(tiffData[offset:], uint32(dpi))
+			order.PutUint32(tiffData[offset+4:], 1)
+		}
+	}
+
+	return nil
+}
+
+// jpegSegment is a marker segment to be written into a JPEG stream.
+type jpegSegment struct {
+	marker  byte
+	payload []byte
+}
+
+// insertJPEGSegments writes an encoded JPEG to w with segments placed
+// directly after the SOI marker, in order.
+func insertJPEGSegments(w io.Writer, jpegData []byte, segments []jpegSegment) error {
+	if len(jpegData) < 2 || jpegData[0] != 0xFF || jpegData[1] != markerSOI {
+		return errors.New("encoded data is not a JPEG")
+	}
+
+	if _, err := w.Write(jpegData[:2]); err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if len(segment.payload)+2 > 0xFFFF {
+			return errors.New("metadata too large for a JPEG segment")
+		}
+		header := []byte{0xFF, segment.marker, 0, 0}
+		binary.BigEndian.PutUint16(header[2:], uint16(len(segment.payload)+2))
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		if _, err := w.Write(segment.payload); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(jpegData[2:])
+	return err
+}
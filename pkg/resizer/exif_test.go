@@ -0,0 +1,80 @@
+package resizer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/jpeg"
+	"testing"
+)
+
+// exifResolution returns an APP1 payload whose first IFD records x and y
+// resolutions as rationals over denominator, in unit (2 for inches, 3 for
+// centimeters).
+func exifResolution(x, y, denominator uint32, unit uint16) []byte {
+	order := binary.LittleEndian
+	const entries = 3
+	ifdSize := 2 + entries*12 + 4
+	rationals := uint32(8 + ifdSize)
+
+	tiff := make([]byte, int(rationals)+16)
+	copy(tiff, "II*\x00")
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], entries)
+
+	entry := func(i int, tag, typ uint16, value uint32) {
+		offset := 10 + i*12
+		order.PutUint16(tiff[offset:], tag)
+		order.PutUint16(tiff[offset+2:], typ)
+		order.PutUint32(tiff[offset+4:], 1)
+		order.PutUint32(tiff[offset+8:], value)
+	}
+	entry(0, tagXResolution, typeRational, rationals)
+	entry(1, tagYResolution, typeRational, rationals+8)
+	entry(2, tagResolutionUnit, typeShort, uint32(unit))
+
+	order.PutUint32(tiff[rationals:], x)
+	order.PutUint32(tiff[rationals+4:], denominator)
+	order.PutUint32(tiff[rationals+8:], y)
+	order.PutUint32(tiff[rationals+12:], denominator)
+
+	return append(append([]byte{}, exifHeader...), tiff...)
+}
+
+// jpegWithEXIF returns a small JPEG carrying payload in an APP1 segment.
+func jpegWithEXIF(t *testing.T, payload []byte) []byte {
+	t.Helper()
+	var plain, out bytes.Buffer
+	if err := jpeg.Encode(&plain, solidImage(8, 8), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := insertJPEGSegments(&out, plain.Bytes(), []jpegSegment{{marker: markerAPP1, payload: payload}}); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestExtractDPIResolutionUnit(t *testing.T) {
+	tests := []struct {
+		name        string
+		x, y        uint32
+		denominator uint32
+		unit        uint16
+		want        int
+	}{
+		{"inches", 300, 300, 1, 2, 300},
+		{"centimeters", 118, 118, 1, 3, 300},             // 299.72 rounds up
+		{"centimeters, rounding down", 28, 28, 1, 3, 71}, // 71.12
+		{"fractional centimeters", 11811, 11811, 100, 3, 300},
+		{"uneven axes use the horizontal", 40, 80, 1, 3, 102},
+	}
+	for _, tt := range tests {
+		data := jpegWithEXIF(t, exifResolution(tt.x, tt.y, tt.denominator, tt.unit))
+		got, err := ExtractDPIFrom(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %d DPI, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package resizer
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+)
+
+// resizeAnimatedGIF scales every frame of an animation to the given size.
+// GIF frames are often partial updates layered on top of earlier ones, so
+// each frame is composited onto a full canvas (honouring its disposal method)
+// and passed to transform, which orients, crops and scales it like a still
+// image, and the output is written as full frames. Frames keep their original
+// palettes, or a gray ramp when grayscale is set, dithered onto them when
+// dither is set.
+func resizeAnimatedGIF(anim *gif.GIF, width, height int, grayscale, dither bool, transform func(image.Image) (image.Image, error)) (*gif.GIF, error) {
+	canvasBounds := image.Rect(0, 0, anim.Config.Width, anim.Config.Height)
+	if canvasBounds.Empty() {
+		canvasBounds = anim.Image[0].Bounds()
+	}
+	canvas := image.NewRGBA(canvasBounds)
+
+	out := &gif.GIF{
+		Image:     make([]*image.Paletted, 0, len(anim.Image)),
+		Delay:     make([]int, 0, len(anim.Image)),
+		Disposal:  make([]byte, 0, len(anim.Image)),
+		LoopCount: anim.LoopCount,
+		Config:    image.Config{Width: width, Height: height},
+	}
+
+	for i, frame := range anim.Image {
+		var previous *image.RGBA
+		disposal := byte(0)
+		if i < len(anim.Disposal) {
+			disposal = anim.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(canvasBounds)
+			draw.Draw(previous, canvasBounds, canvas, canvasBounds.Min, draw.Src)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		resized, err := transform(canvas)
+		if err != nil {
+			return nil, err
+		}
+		palette := frame.Palette
+		if grayscale {
+			palette = grayPalette
+		}
+		paletted := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+		indexedDrawer(dither).Draw(paletted, paletted.Bounds(), resized, resized.Bounds().Min)
+
+		out.Image = append(out.Image, paletted)
+		if i < len(anim.Delay) {
+			out.Delay = append(out.Delay, anim.Delay[i])
+		} else {
+			out.Delay = append(out.Delay, 0)
+		}
+		out.Disposal = append(out.Disposal, gif.DisposalNone)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	return out, nil
+}
+
+// grayPalette holds every 8-bit gray level, for grayscale animations.
+var grayPalette = func() color.Palette {
+	palette := make(color.Palette, 256)
+	for i := range palette {
+		palette[i] = color.Gray{Y: uint8(i)}
+	}
+	return palette
+}()
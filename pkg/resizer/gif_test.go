@@ -0,0 +1,96 @@
+package resizer
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+
+	"github.com/nfnt/resize"
+)
+
+// animatedGIF returns a width x height animation with a frame for each color,
+// filled with that color on the left half and white on the right.
+func animatedGIF(t *testing.T, width, height int, colors ...color.Color) []byte {
+	t.Helper()
+	anim := &gif.GIF{Config: image.Config{Width: width, Height: height}}
+	for _, c := range colors {
+		frame := image.NewPaletted(image.Rect(0, 0, width, height), color.Palette{c, color.White})
+		for y := 0; y < height; y++ {
+			for x := width / 2; x < width; x++ {
+				frame.SetColorIndex(x, y, 1)
+			}
+		}
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+		anim.Disposal = append(anim.Disposal, gif.DisposalNone)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// resizeGIF resizes src with opts and decodes every frame of the output.
+func resizeGIF(t *testing.T, src []byte, opts *Options) *gif.GIF {
+	t.Helper()
+	opts.Format = "gif"
+	opts.GIFAllFrames = true
+	opts.Algorithm = resize.Bilinear
+	var out bytes.Buffer
+	if _, err := Resize(context.Background(), bytes.NewReader(src), &out, 0, opts); err != nil {
+		t.Fatal(err)
+	}
+	anim, err := gif.DecodeAll(&out)
+	if err != nil {
+		t.Fatalf("decoding the output: %v", err)
+	}
+	return anim
+}
+
+func TestAnimatedGIFFramesAreRotated(t *testing.T) {
+	src := animatedGIF(t, 40, 20, color.Black, color.RGBA{255, 0, 0, 255})
+	anim := resizeGIF(t, src, &Options{MaxWidth: 10, Rotate: 90})
+	if len(anim.Image) != 2 {
+		t.Fatalf("wrote %d frames, want 2", len(anim.Image))
+	}
+	for i, frame := range anim.Image {
+		if frame.Bounds().Dx() != 10 || frame.Bounds().Dy() != 20 {
+			t.Fatalf("frame %d is %v, want 10x20", i, frame.Bounds())
+		}
+		// Rotating clockwise moves the colored left half to the top.
+		if r, _, _, _ := frame.At(5, 17).RGBA(); r != 0xffff {
+			t.Errorf("frame %d: the bottom is not white; the frame was not rotated", i)
+		}
+		if _, g, _, _ := frame.At(5, 2).RGBA(); g != 0 {
+			t.Errorf("frame %d: the top is not the frame color; the frame was not rotated", i)
+		}
+	}
+}
+
+func TestAnimatedGIFFramesAreFittedAndGrayed(t *testing.T) {
+	src := animatedGIF(t, 40, 20, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255})
+	anim := resizeGIF(t, src, &Options{MaxWidth: 20, MaxHeight: 20, Fit: "contain", Grayscale: true})
+	if len(anim.Image) != 2 {
+		t.Fatalf("wrote %d frames, want 2", len(anim.Image))
+	}
+	for i, frame := range anim.Image {
+		if frame.Bounds().Dx() != 20 || frame.Bounds().Dy() != 20 {
+			t.Fatalf("frame %d is %v, want 20x20", i, frame.Bounds())
+		}
+		// The 20x10 frame is centered, with white bands above and below.
+		if r, _, _, _ := frame.At(2, 1).RGBA(); r != 0xffff {
+			t.Errorf("frame %d was stretched instead of padded", i)
+		}
+		r, g, b, _ := frame.At(2, 10).RGBA()
+		if r != g || g != b {
+			t.Errorf("frame %d is not gray: %v", i, frame.At(2, 10))
+		}
+		if r == 0xffff {
+			t.Errorf("frame %d lost its left half", i)
+		}
+	}
+}
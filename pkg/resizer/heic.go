@@ -0,0 +1,15 @@
+package resizer
+
+import (
+	"image"
+
+	"github.com/gen2brain/heic"
+)
+
+func init() {
+	// The heic package only recognizes the "heic" brand; cameras and phones
+	// also label HEIF files with these.
+	for _, brand := range []string{"heix", "hevc", "hevx", "mif1", "msf1"} {
+		image.RegisterFormat("heic", "????ftyp"+brand, heic.Decode, heic.DecodeConfig)
+	}
+}
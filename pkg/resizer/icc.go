@@ -0,0 +1,217 @@
+package resizer
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+var (
+	iccHeader    = []byte("ICC_PROFILE\x00")
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// iccChunkSize is the largest profile chunk that fits in one APP2 segment
+// alongside the ICC header, sequence number and chunk count.
+const iccChunkSize = 0xFFFF - 2 - 14
+
+// maxICCProfileSize is the largest profile a JPEG can carry, split over 255
+// APP2 segments. Larger PNG profiles are refused rather than read into memory.
+const maxICCProfileSize = 255 * iccChunkSize
+
+// readICCProfile returns the ICC color profile embedded in a JPEG or PNG
+// stream, or nil if it has none or the format cannot carry one.
+func readICCProfile(src io.Reader, format string) ([]byte, error) {
+	switch format {
+	case "jpeg":
+		return readJPEGICCProfile(src)
+	case "png":
+		return readPNGICCProfile(src)
+	default:
+		return nil, nil
+	}
+}
+
+// iccColorSpace returns the data color space an ICC profile describes, such
+// as "RGB" or "CMYK", or "" if the profile is too short to have a header.
+func iccColorSpace(profile []byte) string {
+	if len(profile) < 20 {
+		return ""
+	}
+	return string(bytes.TrimRight(profile[16:20], " "))
+}
+
+// readJPEGICCProfile reassembles a profile split across APP2 segments.
+func readJPEGICCProfile(src io.Reader) ([]byte, error) {
+	chunks := map[int][]byte{}
+	total := 0
+	err := scanJPEGSegments(src, func(marker byte, payload []byte) bool {
+		if marker != markerAPP2 || !bytes.HasPrefix(payload, iccHeader) || len(payload) < len(iccHeader)+2 {
+			return true
+		}
+		seq, count := int(payload[len(iccHeader)]), int(payload[len(iccHeader)+1])
+		chunks[seq] = payload[len(iccHeader)+2:]
+		total = count
+		return true
+	})
+	if err != nil || len(chunks) == 0 {
+		return nil, err
+	}
+	if len(chunks) != total {
+		return nil, fmt.Errorf("ICC profile has %d of %d chunks", len(chunks), total)
+	}
+
+	seqs := make([]int, 0, len(chunks))
+	for seq := range chunks {
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+
+	var profile []byte
+	for _, seq := range seqs {
+		profile = append(profile, chunks[seq]...)
+	}
+	return profile, nil
+}
+
+// readPNGICCProfile returns the decompressed contents of the iCCP chunk.
+func readPNGICCProfile(src io.Reader) ([]byte, error) {
+	data, err := findPNGChunk(src, "iCCP", maxICCProfileSize)
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	// The profile name is null-terminated and followed by the compression
+	// method, which is always zlib.
+	nameEnd := bytes.IndexByte(data, 0)
+	if nameEnd < 0 || nameEnd+2 > len(data) {
+		return nil, errors.New("malformed iCCP chunk")
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(data[nameEnd+2:]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress ICC profile: %w", err)
+	}
+	defer zr.Close()
+	profile, err := io.ReadAll(io.LimitReader(zr, maxICCProfileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress ICC profile: %w", err)
+	}
+	if len(profile) > maxICCProfileSize {
+		return nil, errors.New("ICC profile is too large")
+	}
+	return profile, nil
+}
+
+// findPNGChunk returns the data of the first chunk of type wanted that comes
+// before the image data, which is where PNG keeps its metadata, or nil if
+// there is none. Other chunks are skipped without being read into memory, and
+// a wanted chunk longer than maxLength is an error. A stream that ends early
+// simply ends the search.
+func findPNGChunk(src io.Reader, wanted string, maxLength uint32) ([]byte, error) {
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(src, signature); err != nil || !bytes.Equal(signature, pngSignature) {
+		return nil, errors.New("not a PNG file")
+	}
+
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(src, header[:]); err != nil {
+			return nil, nil
+		}
+		length := binary.BigEndian.Uint32(header[:4])
+		chunkType := string(header[4:])
+		if chunkType == "IDAT" || chunkType == "IEND" {
+			return nil, nil
+		}
+
+		if chunkType != wanted {
+			// Skip the data and the CRC that follows it.
+			if _, err := io.CopyN(io.Discard, src, int64(length)+4); err != nil {
+				return nil, nil
+			}
+			continue
+		}
+		if length > maxLength {
+			return nil, fmt.Errorf("%s chunk of %d bytes is too large", chunkType, length)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(src, data); err != nil {
+			return nil, fmt.Errorf("failed to read %s chunk: %w", chunkType, err)
+		}
+		return data, nil
+	}
+}
+
+// iccSegments splits profile into the APP2 segments that carry it in a JPEG.
+func iccSegments(profile []byte) []jpegSegment {
+	count := (len(profile) + iccChunkSize - 1) / iccChunkSize
+	segments := make([]jpegSegment, 0, count)
+	for i := 0; i < count; i++ {
+		chunk := profile[i*iccChunkSize : min((i+1)*iccChunkSize, len(profile))]
+		payload := make([]byte, 0, len(iccHeader)+2+len(chunk))
+		payload = append(payload, iccHeader...)
+		payload = append(payload, byte(i+1), byte(count))
+		payload = append(payload, chunk...)
+		segments = append(segments, jpegSegment{marker: markerAPP2, payload: payload})
+	}
+	return segments
+}
+
+// iccpChunk returns the data of a PNG iCCP chunk holding profile.
+func iccpChunk(profile []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("ICC Profile\x00")
+	buf.WriteByte(0) // zlib compression
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(profile); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pngChunk is an ancillary chunk to be written into a PNG stream.
+type pngChunk struct {
+	chunkType string
+	data      []byte
+}
+
+// insertPNGChunks writes an encoded PNG to w with chunks placed directly
+// after the IHDR chunk, where every ancillary chunk is allowed.
+func insertPNGChunks(w io.Writer, pngData []byte, chunks []pngChunk) error {
+	// The signature is followed by IHDR: length, type, 13 bytes of data, CRC.
+	ihdrEnd := len(pngSignature) + 4 + 4 + 13 + 4
+	if len(pngData) < ihdrEnd || !bytes.Equal(pngData[:len(pngSignature)], pngSignature) || string(pngData[12:16]) != "IHDR" {
+		return errors.New("encoded data is not a PNG")
+	}
+
+	if _, err := w.Write(pngData[:ihdrEnd]); err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		var header [8]byte
+		binary.BigEndian.PutUint32(header[:4], uint32(len(chunk.data)))
+		copy(header[4:], chunk.chunkType)
+
+		crc := crc32.NewIEEE()
+		crc.Write(header[4:])
+		crc.Write(chunk.data)
+		var footer [4]byte
+		binary.BigEndian.PutUint32(footer[:], crc.Sum32())
+
+		for _, part := range [][]byte{header[:], chunk.data, footer[:]} {
+			if _, err := w.Write(part); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := w.Write(pngData[ihdrEnd:])
+	return err
+}
@@ -0,0 +1,62 @@
+package resizer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// rawPNGChunk returns a PNG chunk of chunkType that declares length bytes of
+// data but carries only data, for streams that lie about their sizes.
+func rawPNGChunk(chunkType string, length uint32, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, length)
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	return append(chunk, 0, 0, 0, 0) // CRC, which is not checked
+}
+
+func TestFindPNGChunk(t *testing.T) {
+	stream := func(chunks ...[]byte) []byte {
+		return bytes.Join(append([][]byte{pngSignature}, chunks...), nil)
+	}
+	tests := []struct {
+		name    string
+		src     []byte
+		want    []byte
+		wantErr bool
+	}{
+		{"found", stream(rawPNGChunk("tEXt", 2, []byte("hi")), rawPNGChunk("iCCP", 3, []byte("abc"))), []byte("abc"), false},
+		{"after the image data", stream(rawPNGChunk("IDAT", 0, nil), rawPNGChunk("iCCP", 3, []byte("abc"))), nil, false},
+		{"skipped chunk claims 4 GB", stream(rawPNGChunk("tEXt", 0xFFFFFFF0, []byte("hi"))), nil, false},
+		{"wanted chunk claims 4 GB", stream(rawPNGChunk("iCCP", 0xFFFFFFF0, []byte("abc"))), nil, true},
+		{"truncated", stream(rawPNGChunk("iCCP", 10, []byte("abc"))), nil, true},
+		{"not a PNG", []byte("GIF89a"), nil, true},
+	}
+	for _, tt := range tests {
+		got, err := findPNGChunk(bytes.NewReader(tt.src), "iCCP", maxICCProfileSize)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReadPNGICCProfileSizeLimit(t *testing.T) {
+	for _, size := range []int{1000, maxICCProfileSize + 1} {
+		data, err := iccpChunk(make([]byte, size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := bytes.Join([][]byte{pngSignature, rawPNGChunk("iCCP", uint32(len(data)), data)}, nil)
+		profile, err := readPNGICCProfile(bytes.NewReader(src))
+		if size > maxICCProfileSize {
+			if err == nil {
+				t.Errorf("read a %d-byte profile, want an error", len(profile))
+			}
+		} else if err != nil || len(profile) != size {
+			t.Errorf("read %d bytes (error %v), want %d", len(profile), err, size)
+		}
+	}
+}
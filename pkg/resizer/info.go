@@ -0,0 +1,61 @@
+package resizer
+
+import (
+	"fmt"
+	"image"
+	"os"
+)
+
+// ImageInfo describes an image as read from its header and metadata, without
+// decoding its pixels.
+type ImageInfo struct {
+	Format      string
+	Width       int
+	Height      int
+	Bytes       int64
+	DPI         int // zero when the file records no resolution
+	Orientation int // EXIF orientation 1-8, or zero when absent
+	// DecodedBytes is the size of the image's bitmap as --memory measures
+	// it, with rows padded to four bytes. Images within that limit are left
+	// unchanged.
+	DecodedBytes int64
+}
+
+// Inspect reads the header of the image at filePath along with its DPI and
+// EXIF orientation.
+func Inspect(filePath string) (ImageInfo, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return ImageInfo{}, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return ImageInfo{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	config, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return ImageInfo{}, fmt.Errorf("unrecognized image format: %w", err)
+	}
+	bytesPerPixel, err := GetBytesPerPixel(getModelPixelFormat(config.ColorModel))
+	if err != nil {
+		return ImageInfo{}, err
+	}
+
+	info := ImageInfo{
+		Format: format,
+		Width:  config.Width,
+		Height: config.Height,
+		Bytes:  stat.Size(),
+	}
+	stride := (int64(config.Width)*int64(bytesPerPixel) + 3) / 4 * 4
+	info.DecodedBytes = stride * int64(config.Height)
+	if dpi, err := ExtractDPI(filePath); err == nil {
+		info.DPI = dpi
+	}
+	if orientation, err := ExtractOrientation(filePath); err == nil {
+		info.Orientation = orientation
+	}
+	return info, nil
+}
@@ -0,0 +1,28 @@
+package resizer
+
+import "fmt"
+
+// ChromaSubsamplings lists the accepted values of Options.Subsampling. The
+// numbers follow the usual J:a:b notation: "444" keeps full color
+// resolution, "422" halves it horizontally and "420" in both directions.
+var ChromaSubsamplings = []string{"444", "422", "420"}
+
+// samplingFactors returns the horizontal and vertical luma sampling factors
+// libjpeg uses for subsampling. Empty means "420".
+func samplingFactors(subsampling string) (int, int, error) {
+	switch subsampling {
+	case "444":
+		return 1, 1, nil
+	case "422":
+		return 2, 1, nil
+	case "", "420":
+		return 2, 2, nil
+	}
+	return 0, 0, fmt.Errorf("invalid chroma subsampling %q (valid values: 444, 422, 420)", subsampling)
+}
+
+// needsLibJPEG reports whether opts asks for JPEG features the standard
+// library encoder lacks.
+func needsLibJPEG(opts *Options) bool {
+	return opts.Progressive || (opts.Subsampling != "" && opts.Subsampling != "420")
+}
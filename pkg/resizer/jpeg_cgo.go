@@ -0,0 +1,128 @@
+//go:build libjpeg && cgo
+
+package resizer
+
+/*
+#cgo pkg-config: libjpeg
+#include <stdio.h>
+#include <stdlib.h>
+#include <setjmp.h>
+#include <jpeglib.h>
+
+struct errorManager {
+	struct jpeg_error_mgr pub;
+	jmp_buf jump;
+};
+
+// onError replaces libjpeg's default handler, which exits the process.
+static void onError(j_common_ptr cinfo) {
+	struct errorManager *err = (struct errorManager *)cinfo->err;
+	longjmp(err->jump, 1);
+}
+
+// encodeJPEG encodes an 8-bit RGB or grayscale buffer. hSamp and vSamp are
+// the luma sampling factors relative to chroma. On success *out holds the
+// encoded file, which the caller frees with free.
+static int encodeJPEG(unsigned char *pixels, int width, int height, int stride, int components, int quality, int progressive, int hSamp, int vSamp, unsigned char **out, unsigned long *outSize) {
+	struct jpeg_compress_struct cinfo;
+	struct errorManager err;
+
+	cinfo.err = jpeg_std_error(&err.pub);
+	err.pub.error_exit = onError;
+	*out = NULL;
+	*outSize = 0;
+	if (setjmp(err.jump)) {
+		// The output buffer may already have been reallocated by libjpeg,
+		// so it is not freed here.
+		jpeg_destroy_compress(&cinfo);
+		return 0;
+	}
+
+	jpeg_create_compress(&cinfo);
+	jpeg_mem_dest(&cinfo, out, outSize);
+	cinfo.image_width = width;
+	cinfo.image_height = height;
+	cinfo.input_components = components;
+	cinfo.in_color_space = components == 1 ? JCS_GRAYSCALE : JCS_RGB;
+	jpeg_set_defaults(&cinfo);
+	jpeg_set_quality(&cinfo, quality, TRUE);
+	if (components == 3) {
+		cinfo.comp_info[0].h_samp_factor = hSamp;
+		cinfo.comp_info[0].v_samp_factor = vSamp;
+	}
+	if (progressive) {
+		jpeg_simple_progression(&cinfo);
+	}
+	// The JFIF segment is written by the caller, which knows the DPI.
+	cinfo.write_JFIF_header = FALSE;
+
+	jpeg_start_compress(&cinfo, TRUE);
+	while (cinfo.next_scanline < cinfo.image_height) {
+		JSAMPROW row = pixels + (size_t)cinfo.next_scanline * stride;
+		jpeg_write_scanlines(&cinfo, &row, 1);
+	}
+	jpeg_finish_compress(&cinfo);
+	jpeg_destroy_compress(&cinfo);
+	return 1;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"unsafe"
+)
+
+// LibJPEG reports whether this build includes the libjpeg encoder, which
+// progressive JPEGs and chroma subsampling other than 4:2:0 need.
+const LibJPEG = true
+
+// encodeLibJPEG wraps libjpeg. Progressive output uses its standard scan
+// script, which splits the image into several passes of increasing detail.
+func encodeLibJPEG(w io.Writer, img image.Image, quality int, progressive bool, subsampling string) error {
+	hSamp, vSamp, err := samplingFactors(subsampling)
+	if err != nil {
+		return err
+	}
+
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return fmt.Errorf("cannot encode an empty image")
+	}
+
+	var pix []byte
+	var stride, components int
+	if gray, ok := img.(*image.Gray); ok {
+		pix, stride, components = gray.Pix[gray.PixOffset(bounds.Min.X, bounds.Min.Y):], gray.Stride, 1
+	} else {
+		rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+		// Pack the pixels as RGB, which is what libjpeg reads.
+		pix = make([]byte, 0, bounds.Dx()*bounds.Dy()*3)
+		for i := 0; i < len(rgba.Pix); i += 4 {
+			pix = append(pix, rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2])
+		}
+		stride, components = bounds.Dx()*3, 3
+	}
+
+	var progressiveFlag C.int
+	if progressive {
+		progressiveFlag = 1
+	}
+
+	// libjpeg only reads the pixels during the call, so the Go buffer can
+	// be passed directly.
+	var out *C.uchar
+	var size C.ulong
+	ok := C.encodeJPEG((*C.uchar)(unsafe.Pointer(&pix[0])), C.int(bounds.Dx()), C.int(bounds.Dy()), C.int(stride), C.int(components), C.int(quality), progressiveFlag, C.int(hSamp), C.int(vSamp), &out, &size)
+	if ok == 0 {
+		return fmt.Errorf("libjpeg could not encode the image")
+	}
+	defer C.free(unsafe.Pointer(out))
+
+	_, err = w.Write(C.GoBytes(unsafe.Pointer(out), C.int(size)))
+	return err
+}
@@ -0,0 +1,20 @@
+//go:build !libjpeg || !cgo
+
+package resizer
+
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+// LibJPEG reports whether this build includes the libjpeg encoder, which
+// progressive JPEGs and chroma subsampling other than 4:2:0 need.
+const LibJPEG = false
+
+// The standard library only writes baseline 4:2:0 JPEGs, so the encoder for
+// everything else wraps libjpeg and is only built with the libjpeg tag in cgo
+// builds.
+func encodeLibJPEG(w io.Writer, img image.Image, quality int, progressive bool, subsampling string) error {
+	return errors.New("progressive JPEGs and 4:4:4 or 4:2:2 subsampling require a cgo build with the libjpeg tag and libjpeg installed")
+}
@@ -0,0 +1,20 @@
+package resizer
+
+// optimizedBitsPerPixel is the compressed size below which a JPEG is taken to
+// have been optimized already. Photographs saved at quality 75 take about 2
+// bits per pixel; files well under that have usually been through an
+// optimizer or a low quality setting, and re-encoding them mostly adds
+// artifacts.
+const optimizedBitsPerPixel = 1.0
+
+// looksOptimized reports whether a source file of sourceBytes holding a
+// width x height image is compressed tightly enough that it was probably
+// optimized already, along with its bits per pixel. Only JPEGs are judged, as
+// other formats are either lossless or rarely re-encoded in place.
+func looksOptimized(format string, sourceBytes int64, width, height int) (float64, bool) {
+	if format != "jpeg" || sourceBytes <= 0 || width <= 0 || height <= 0 {
+		return 0, false
+	}
+	bits := float64(sourceBytes) * 8 / (float64(width) * float64(height))
+	return bits, bits < optimizedBitsPerPixel
+}
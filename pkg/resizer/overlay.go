@@ -0,0 +1,187 @@
+package resizer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/nfnt/resize"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// DefaultWatermarkScale is the share of the output width and height a
+// watermark may cover when Watermark.Scale is zero.
+const DefaultWatermarkScale = 0.2
+
+// DefaultCaptionSize is the caption height, as a share of the shorter side of
+// the output, used when Caption.Size is zero.
+const DefaultCaptionSize = 0.04
+
+// overlayMargin is the gap between an overlay and the image edges, as a
+// share of the shorter side.
+const overlayMargin = 0.02
+
+// minCaptionPixels keeps captions on small thumbnails readable.
+const minCaptionPixels = 10
+
+// OverlayPositions lists the accepted values of Watermark.Position and
+// Caption.Position.
+var OverlayPositions = []string{"top-left", "top-right", "bottom-left", "bottom-right", "center"}
+
+// captionFont parses the bundled Go Regular typeface on first use.
+var captionFont = sync.OnceValues(func() (*opentype.Font, error) {
+	return opentype.Parse(goregular.TTF)
+})
+
+// Watermark is an overlay stamped onto every resized image. It is scaled
+// with each output so it covers the same share of every image.
+type Watermark struct {
+	Image image.Image
+	// Position is one of OverlayPositions; empty means "bottom-right".
+	Position string
+	// Opacity ranges from 0 (invisible) to 1 (as drawn).
+	Opacity float64
+	// Scale is the share of the output width and height the watermark may
+	// cover; zero means DefaultWatermarkScale.
+	Scale float64
+}
+
+// Caption is a line of text burned into every resized image. "{name}" in
+// Text is replaced by the source file name.
+type Caption struct {
+	Text string
+	// Position is one of OverlayPositions; empty means "bottom-left".
+	Position string
+	// Color is the text color; nil means white.
+	Color color.Color
+	// Background, if set, fills a box behind the text.
+	Background color.Color
+	// Size is the text height as a share of the shorter side of the output;
+	// zero means DefaultCaptionSize.
+	Size float64
+}
+
+// ValidOverlayPosition reports whether position is one of OverlayPositions.
+func ValidOverlayPosition(position string) bool {
+	for _, valid := range OverlayPositions {
+		if position == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// overlayOrigin returns where the top-left corner of a width x height overlay
+// goes on bounds for the given position.
+func overlayOrigin(bounds image.Rectangle, width, height int, position string) (image.Point, error) {
+	margin := int(math.Round(float64(min(bounds.Dx(), bounds.Dy())) * overlayMargin))
+	var x, y int
+	switch position {
+	case "top-left":
+		x, y = margin, margin
+	case "top-right":
+		x, y = bounds.Dx()-width-margin, margin
+	case "bottom-left":
+		x, y = margin, bounds.Dy()-height-margin
+	case "bottom-right":
+		x, y = bounds.Dx()-width-margin, bounds.Dy()-height-margin
+	case "center":
+		x, y = (bounds.Dx()-width)/2, (bounds.Dy()-height)/2
+	default:
+		return image.Point{}, fmt.Errorf("invalid overlay position %q", position)
+	}
+	return bounds.Min.Add(image.Pt(x, y)), nil
+}
+
+// applyWatermark returns img with wm drawn over it.
+func applyWatermark(img image.Image, wm *Watermark, algorithm resize.InterpolationFunction) (image.Image, error) {
+	position := wm.Position
+	if position == "" {
+		position = "bottom-right"
+	}
+	scale := wm.Scale
+	if scale <= 0 {
+		scale = DefaultWatermarkScale
+	}
+
+	dst, _ := toPixelBuffer(img)
+	bounds := dst.Bounds()
+	markBounds := wm.Image.Bounds()
+	markWidth, markHeight := CalculateMaxDimensions(markBounds.Dx(), markBounds.Dy(),
+		max(1, int(float64(bounds.Dx())*scale)), max(1, int(float64(bounds.Dy())*scale)), true)
+	mark := resize.Resize(uint(markWidth), uint(markHeight), wm.Image, algorithm)
+
+	origin, err := overlayOrigin(bounds, markWidth, markHeight, position)
+	if err != nil {
+		return nil, err
+	}
+
+	opacity := uint8(math.Round(math.Max(0, math.Min(1, wm.Opacity)) * 255))
+	rect := image.Rectangle{Min: origin, Max: origin.Add(image.Pt(markWidth, markHeight))}
+	draw.DrawMask(dst, rect, mark, mark.Bounds().Min, image.NewUniform(color.Alpha{A: opacity}), image.Point{}, draw.Over)
+	return dst, nil
+}
+
+// applyCaption returns img with caption drawn over it. name is the source
+// file, substituted for "{name}".
+func applyCaption(img image.Image, caption *Caption, name string) (image.Image, error) {
+	text := strings.ReplaceAll(caption.Text, "{name}", filepath.Base(name))
+	position := caption.Position
+	if position == "" {
+		position = "bottom-left"
+	}
+	textColor := caption.Color
+	if textColor == nil {
+		textColor = color.White
+	}
+	size := caption.Size
+	if size <= 0 {
+		size = DefaultCaptionSize
+	}
+
+	typeface, err := captionFont()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load caption font: %w", err)
+	}
+
+	dst, _ := toPixelBuffer(img)
+	bounds := dst.Bounds()
+	pixels := max(minCaptionPixels, float64(min(bounds.Dx(), bounds.Dy()))*size)
+	face, err := opentype.NewFace(typeface, &opentype.FaceOptions{Size: pixels, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load caption font: %w", err)
+	}
+	defer face.Close()
+
+	metrics := face.Metrics()
+	padding := int(math.Round(pixels * 0.25))
+	textWidth := font.MeasureString(face, text).Ceil()
+	textHeight := (metrics.Ascent + metrics.Descent).Ceil()
+	boxWidth, boxHeight := textWidth+2*padding, textHeight+2*padding
+
+	origin, err := overlayOrigin(bounds, boxWidth, boxHeight, position)
+	if err != nil {
+		return nil, err
+	}
+	if caption.Background != nil {
+		box := image.Rectangle{Min: origin, Max: origin.Add(image.Pt(boxWidth, boxHeight))}
+		draw.Draw(dst, box, image.NewUniform(caption.Background), image.Point{}, draw.Over)
+	}
+
+	drawer := &font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(textColor),
+		Face: face,
+		Dot:  fixed.P(origin.X+padding, origin.Y+padding).Add(fixed.Point26_6{Y: metrics.Ascent}),
+	}
+	drawer.DrawString(text)
+	return dst, nil
+}
@@ -0,0 +1,122 @@
+package resizer
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+// maxPaletteSamples bounds the pixels a palette is built from; larger images
+// are sampled evenly.
+const maxPaletteSamples = 1 << 16
+
+// quantizeImage converts img to 256 colors for indexed output. The palette
+// is chosen by median cut from the image's own colors, which bands far less
+// than a fixed palette. With dither set, the remaining error is spread with
+// Floyd-Steinberg dithering; otherwise each pixel takes the nearest color.
+func quantizeImage(img image.Image, dither bool) *image.Paletted {
+	if paletted, ok := img.(*image.Paletted); ok {
+		return paletted
+	}
+	bounds := img.Bounds()
+	paletted := image.NewPaletted(bounds, medianCutPalette(img, 256))
+	indexedDrawer(dither).Draw(paletted, bounds, img, bounds.Min)
+	return paletted
+}
+
+// indexedDrawer returns the drawer that maps pixels onto a palette.
+func indexedDrawer(dither bool) draw.Drawer {
+	if dither {
+		return draw.FloydSteinberg
+	}
+	return draw.Src
+}
+
+// medianCutPalette returns up to size colors representing img. The sampled
+// colors are split repeatedly at the median of their widest channel, and each
+// group is replaced by its average. One entry is kept for transparency when
+// the image has transparent pixels.
+func medianCutPalette(img image.Image, size int) color.Palette {
+	bounds := img.Bounds()
+	step := 1
+	for bounds.Dx()*bounds.Dy()/(step*step) > maxPaletteSamples {
+		step++
+	}
+
+	var samples [][3]uint8
+	transparent := false
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A < 0x80 {
+				transparent = true
+				continue
+			}
+			samples = append(samples, [3]uint8{c.R, c.G, c.B})
+		}
+	}
+
+	var palette color.Palette
+	if transparent {
+		palette = append(palette, color.NRGBA{})
+		size--
+	}
+	if len(samples) == 0 {
+		return append(palette, color.Black)
+	}
+
+	boxes := [][][3]uint8{samples}
+	for len(boxes) < size {
+		widest, channel, span := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if c, s := widestChannel(box); s > span {
+				widest, channel, span = i, c, s
+			}
+		}
+		if widest < 0 {
+			break
+		}
+
+		box := boxes[widest]
+		sort.Slice(box, func(a, b int) bool { return box[a][channel] < box[b][channel] })
+		mid := len(box) / 2
+		boxes[widest] = box[:mid]
+		boxes = append(boxes, box[mid:])
+	}
+
+	for _, box := range boxes {
+		var sum [3]int
+		for _, c := range box {
+			sum[0] += int(c[0])
+			sum[1] += int(c[1])
+			sum[2] += int(c[2])
+		}
+		n := len(box)
+		palette = append(palette, color.RGBA{uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n), 0xff})
+	}
+	return palette
+}
+
+// widestChannel returns the channel with the largest range of values in box,
+// and that range.
+func widestChannel(box [][3]uint8) (int, int) {
+	lo := [3]uint8{255, 255, 255}
+	var hi [3]uint8
+	for _, c := range box {
+		for i := range c {
+			lo[i] = min(lo[i], c[i])
+			hi[i] = max(hi[i], c[i])
+		}
+	}
+	channel := 0
+	for i := 1; i < 3; i++ {
+		if int(hi[i])-int(lo[i]) > int(hi[channel])-int(lo[channel]) {
+			channel = i
+		}
+	}
+	return channel, int(hi[channel]) - int(lo[channel])
+}
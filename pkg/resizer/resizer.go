@@ -0,0 +1,1273 @@
+// Package resizer shrinks images to fit within a memory limit, pixel
+// dimensions, or a scale factor, and saves them in any of the supported
+// formats. It is the engine behind the resizer command.
+package resizer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nfnt/resize"
+	"github.com/rwcarlsen/goexif/exif"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// PixelFormat describes the memory layout of a bitmap.
+type PixelFormat int
+
+const (
+	Format8bppIndexed PixelFormat = iota
+	Format8bppGrayscale
+	Format16bppGrayscale
+	Format24bppRgb
+	Format32bppArgb
+	Format64bppArgb
+)
+
+// Options controls how ResizeImage sizes and saves an image. The zero value
+// leaves images at their original size.
+type Options struct {
+	// MemoryLimit caps the uncompressed size of the output bitmap in bytes.
+	MemoryLimit int64
+	// MaxPixels rejects sources whose header declares more pixels than this,
+	// before any pixel data is decoded; zero means no limit.
+	MaxPixels int64
+	// MaxWidth and MaxHeight cap the output dimensions; zero means no cap.
+	MaxWidth  int
+	MaxHeight int
+	// Scale resizes by a fixed factor instead of MemoryLimit when above zero.
+	Scale        float64
+	AllowUpscale bool
+	// TargetDPI resamples images so they print at the same physical size as
+	// the source at this resolution, when above zero.
+	TargetDPI int
+	// SnapToDPI rounds widths chosen by MemoryLimit down to a multiple of the
+	// source DPI, so the output covers a whole number of inches.
+	SnapToDPI bool
+
+	// Fit is "contain", "cover" or "stretch" to produce exactly MaxWidth x
+	// MaxHeight, or empty to preserve the aspect ratio.
+	Fit string
+	// CropAspect crops to a width/height ratio before resizing when above zero.
+	CropAspect  float64
+	CropGravity string
+
+	Algorithm resize.InterpolationFunction
+	// Format is the output format; empty keeps the source format.
+	Format string
+	// Quality applies to JPEG, lossy WebP and AVIF output. FormatQuality,
+	// keyed by format name, overrides it for individual formats.
+	Quality       int
+	FormatQuality map[string]int
+	// TargetSize lowers Quality as far as needed, for formats that have
+	// one, to keep each output within this many bytes; zero means no target.
+	TargetSize int64
+	// MinSSIM lowers Quality as far as it can, for JPEG and lossy WebP
+	// output, while the output keeps at least this structural similarity to
+	// the resized image; zero turns the search off.
+	MinSSIM float64
+	// Progressive writes JPEGs that render in several passes of increasing
+	// detail, and Subsampling is one of ChromaSubsamplings, empty meaning
+	// "420". Both need a build with LibJPEG set unless left at their
+	// defaults.
+	Progressive     bool
+	Subsampling     string
+	Lossless        bool
+	PNGCompression  png.CompressionLevel
+	TIFFCompression tiff.CompressionType
+	// Background fills transparent areas in formats without alpha and the
+	// padding added by the "contain" fit.
+	Background   color.Color
+	GIFAllFrames bool
+	// Dither applies Floyd-Steinberg dithering when reducing images to the
+	// 256 colors of GIF output, trading banding in gradients for fine noise.
+	Dither       bool
+	PreserveEXIF bool
+	// RegenerateThumbnail replaces the thumbnail in preserved EXIF data with
+	// one made from the resized image.
+	RegenerateThumbnail bool
+	// PreserveICC carries the ICC color profile of JPEG and PNG sources
+	// into JPEG and PNG output.
+	PreserveICC bool
+	AutoOrient  bool
+	// Rotate turns images clockwise by 90, 180 or 270 degrees, and Flip
+	// mirrors them "horizontal"ly or "vertical"ly, after any automatic
+	// orientation.
+	Rotate int
+	Flip   string
+	// Grayscale converts images to 8-bit grayscale before resizing, so the
+	// memory limit is worked out at one byte per pixel.
+	Grayscale bool
+	// PreserveModTime gives ResizeImage outputs the modification time of
+	// their source file.
+	PreserveModTime bool
+	// Watermark and Caption, if set, are drawn onto every image after
+	// resizing.
+	Watermark *Watermark
+	Caption   *Caption
+	// Outputs that would not be smaller than their source are discarded,
+	// leaving any existing file untouched, and reported as skipped with
+	// Result.Larger set. This applies to plain resizes unless KeepLarger is
+	// set; SkipIfLarger extends it to format conversions and images that are
+	// rotated, converted to grayscale or overlaid, which are otherwise written
+	// whatever their size.
+	KeepLarger   bool
+	SkipIfLarger bool
+	// SkipOptimized leaves JPEGs that are already heavily compressed, by
+	// the measure of looksOptimized, as they are instead of resizing them.
+	SkipOptimized bool
+
+	// DryRun computes and reports the result without writing anything.
+	DryRun bool
+	// Budget, if set, is shared with other calls to limit decoded memory.
+	Budget *MemoryBudget
+	// Logger receives progress messages; nil discards them.
+	Logger Logger
+}
+
+// Logger receives the messages ResizeImage produces. It may be called from
+// several goroutines at once.
+type Logger interface {
+	Debug(message string)
+	Info(message string)
+	Warn(message string)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string) {}
+func (nopLogger) Info(string)  {}
+func (nopLogger) Warn(string)  {}
+
+// altersPixels reports whether opts change an image in ways that make it
+// worth writing even when it needs no resizing.
+func (opts *Options) altersPixels() bool {
+	return opts.Watermark != nil || opts.Caption != nil || opts.Rotate != 0 || opts.Flip != "" || opts.Grayscale
+}
+
+func (opts *Options) logger() Logger {
+	if opts.Logger == nil {
+		return nopLogger{}
+	}
+	return opts.Logger
+}
+
+// Result describes what happened to a single image.
+type Result struct {
+	Format      string
+	Skipped     bool
+	OriginalW   int
+	OriginalH   int
+	NewW        int
+	NewH        int
+	DPI         int // zero when no resolution was recorded in the output
+	OutputPath  string
+	SourceBytes int64
+	OutputBytes int64
+	// EstimatedBytes is a rough guess at the output size, set by dry runs
+	// in place of OutputBytes.
+	EstimatedBytes int64
+	// Quality is the encoding quality chosen to meet Options.TargetSize or
+	// Options.MinSSIM, and SSIM the similarity measured at that quality.
+	Quality int
+	SSIM    float64
+	// Larger reports that the output was discarded because it was not
+	// smaller than the source.
+	Larger bool
+}
+
+// OutputPathFunc returns the path a resized image of the given size should be
+// written to, or an empty string if the file should be skipped. dpi is the
+// resolution recorded in the output, or zero if none is.
+type OutputPathFunc func(width, height, dpi int) string
+
+// DefaultDPI is the source resolution assumed when sizing images that record
+// none, as most software does. It is never written into an output.
+const DefaultDPI = 72
+
+// maxResolutionIterations bounds the search in CalculateMaxResolution so a
+// pathological limit or aspect ratio can never stall a batch.
+const maxResolutionIterations = 100
+
+// CalculateMaxResolution returns the largest size with the original aspect
+// ratio whose bitmap, with rows padded to alignment bytes, fits within
+// memoryLimit. The width is snapped down to a multiple of dpi when possible.
+// The result is never smaller than 1x1, so a limit too small for even one
+// padded row returns 1x1, which exceeds it.
+func CalculateMaxResolution(originalWidth, originalHeight int, pixelFormat PixelFormat, alignment int, memoryLimit int64, dpi int) (int, int, error) {
+	return calculateMaxResolution(originalWidth, originalHeight, pixelFormat, alignment, memoryLimit, dpi, nopLogger{})
+}
+
+func calculateMaxResolution(originalWidth, originalHeight int, pixelFormat PixelFormat, alignment int, memoryLimit int64, dpi int, log Logger) (int, int, error) {
+	if originalWidth <= 0 || originalHeight <= 0 {
+		return 0, 0, fmt.Errorf("invalid image dimensions %dx%d", originalWidth, originalHeight)
+	}
+	bytesPerPixel, err := GetBytesPerPixel(pixelFormat)
+	if err != nil {
+		return 0, 0, err
+	}
+	alignment = max(1, alignment)
+	aspectRatio := float64(originalWidth) / float64(originalHeight)
+	estimatedHeight := math.Sqrt(float64(memoryLimit) / (float64(bytesPerPixel) * aspectRatio))
+
+	for i := 0; i < maxResolutionIterations; i++ {
+		height := max(1, int(math.Floor(estimatedHeight)))
+		width := max(1, int(math.Floor(aspectRatio*float64(height))))
+		stride := (int64(width)*int64(bytesPerPixel) + int64(alignment) - 1) / int64(alignment) * int64(alignment)
+		totalMemory := stride * int64(height)
+
+		log.Debug(fmt.Sprintf("Trying %dx%d: stride %d bytes, %d bytes total (limit %d)", width, height, stride, totalMemory, memoryLimit))
+
+		if totalMemory <= memoryLimit {
+			newWidth := width
+			// Only snap to a multiple of the DPI when that leaves something.
+			if dpi > 0 && width >= dpi {
+				newWidth = width - (width % dpi)
+			}
+			newHeight := max(1, int(float64(newWidth)/aspectRatio))
+			return newWidth, newHeight, nil
+		}
+		if height == 1 {
+			// A single row cannot get shorter, so give up the aspect ratio
+			// and keep as many columns as the limit allows.
+			width = max(1, int(memoryLimit/int64(bytesPerPixel)))
+			return width, 1, nil
+		}
+
+		// Always make progress, even when the estimate fails to shrink.
+		next := float64(height) * math.Sqrt(float64(memoryLimit)/float64(totalMemory))
+		estimatedHeight = math.Min(next, float64(height-1))
+	}
+
+	log.Debug(fmt.Sprintf("No resolution fits within %d bytes; falling back to 1x1", memoryLimit))
+	return 1, 1, nil
+}
+
+// CalculateMaxDimensions scales the image to fit within maxWidth and maxHeight
+// while preserving its aspect ratio. A zero limit is ignored. Images are only
+// enlarged to meet the limits when allowUpscale is set.
+func CalculateMaxDimensions(originalWidth, originalHeight, maxWidth, maxHeight int, allowUpscale bool) (int, int) {
+	scale := math.Inf(1)
+	if maxWidth > 0 {
+		scale = math.Min(scale, float64(maxWidth)/float64(originalWidth))
+	}
+	if maxHeight > 0 {
+		scale = math.Min(scale, float64(maxHeight)/float64(originalHeight))
+	}
+	if math.IsInf(scale, 1) || (scale >= 1 && !allowUpscale) {
+		return originalWidth, originalHeight
+	}
+
+	newWidth := int(math.Max(1, math.Round(float64(originalWidth)*scale)))
+	newHeight := int(math.Max(1, math.Round(float64(originalHeight)*scale)))
+	return newWidth, newHeight
+}
+
+// CalculateTargetResolution applies whichever size constraints are set and
+// returns the most restrictive result.
+func CalculateTargetResolution(originalWidth, originalHeight int, pixelFormat PixelFormat, dpi int, opts *Options) (int, int, error) {
+	// Fit modes aim for exactly the requested size; see needsResize for
+	// sources smaller than it.
+	if opts.Fit != "" {
+		return opts.MaxWidth, opts.MaxHeight, nil
+	}
+
+	newWidth, newHeight := originalWidth, originalHeight
+	constrained := false
+
+	if opts.Scale > 0 {
+		newWidth = int(math.Max(1, math.Round(float64(originalWidth)*opts.Scale)))
+		newHeight = int(math.Max(1, math.Round(float64(originalHeight)*opts.Scale)))
+		constrained = true
+	} else if opts.MemoryLimit > 0 {
+		var err error
+		snapDPI := 0
+		if opts.SnapToDPI {
+			snapDPI = dpi
+		}
+		newWidth, newHeight, err = calculateMaxResolution(originalWidth, originalHeight, pixelFormat, 4, opts.MemoryLimit, snapDPI, opts.logger())
+		if err != nil {
+			return 0, 0, err
+		}
+		constrained = true
+	}
+
+	// Keeping the print size at a new DPI scales the pixels by the ratio of
+	// the two resolutions.
+	if opts.TargetDPI > 0 && dpi > 0 {
+		scale := float64(opts.TargetDPI) / float64(dpi)
+		if scale < 1 || opts.AllowUpscale {
+			width := int(math.Max(1, math.Round(float64(originalWidth)*scale)))
+			height := int(math.Max(1, math.Round(float64(originalHeight)*scale)))
+			if !constrained || width < newWidth || height < newHeight {
+				newWidth, newHeight = width, height
+			}
+			constrained = true
+		}
+	}
+
+	if opts.MaxWidth > 0 || opts.MaxHeight > 0 {
+		width, height := CalculateMaxDimensions(originalWidth, originalHeight, opts.MaxWidth, opts.MaxHeight, opts.AllowUpscale)
+		if !constrained || width < newWidth || height < newHeight {
+			newWidth, newHeight = width, height
+		}
+	}
+
+	return newWidth, newHeight, nil
+}
+
+// GetBytesPerPixel returns the size of one pixel in pixelFormat.
+func GetBytesPerPixel(pixelFormat PixelFormat) (int, error) {
+	switch pixelFormat {
+	case Format8bppIndexed, Format8bppGrayscale:
+		return 1, nil
+	case Format16bppGrayscale:
+		return 2, nil
+	case Format24bppRgb:
+		return 3, nil
+	case Format32bppArgb:
+		return 4, nil
+	case Format64bppArgb:
+		return 8, nil
+	default:
+		return 0, fmt.Errorf("unsupported pixel format: %v", pixelFormat)
+	}
+}
+
+// getDecodedPixelFormat reports the layout of the buffer image.Decode actually
+// allocated, which often differs from the file's native bit depth.
+func getDecodedPixelFormat(img image.Image) PixelFormat {
+	switch img.(type) {
+	case *image.Paletted:
+		return Format8bppIndexed
+	case *image.Gray, *image.Alpha:
+		return Format8bppGrayscale
+	case *image.Gray16, *image.Alpha16:
+		return Format16bppGrayscale
+	case *image.YCbCr:
+		// The resizer expands chroma to full resolution, so budget 3 bytes
+		// per pixel rather than the subsampled source size.
+		return Format24bppRgb
+	case *image.CMYK:
+		// CMYK is converted to RGBA straight after decoding, which also
+		// takes 4 bytes per pixel.
+		return Format32bppArgb
+	case *image.RGBA64, *image.NRGBA64:
+		return Format64bppArgb
+	default:
+		return Format32bppArgb
+	}
+}
+
+// getModelPixelFormat predicts the pixel layout image.Decode will allocate
+// for a color model reported by image.DecodeConfig.
+func getModelPixelFormat(model color.Model) PixelFormat {
+	switch model {
+	case color.GrayModel, color.AlphaModel:
+		return Format8bppGrayscale
+	case color.Gray16Model, color.Alpha16Model:
+		return Format16bppGrayscale
+	case color.YCbCrModel:
+		return Format24bppRgb
+	case color.CMYKModel:
+		return Format32bppArgb
+	case color.RGBA64Model, color.NRGBA64Model:
+		return Format64bppArgb
+	}
+	if _, ok := model.(color.Palette); ok {
+		return Format8bppIndexed
+	}
+	return Format32bppArgb
+}
+
+// DetectFormat returns the format of the image at filePath, such as "jpeg" or
+// "heic", judged from its contents rather than its name.
+func DetectFormat(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	_, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return "", fmt.Errorf("unrecognized image format: %w", err)
+	}
+	return format, nil
+}
+
+// ExtractDPI returns the horizontal resolution recorded in the image at
+// filePath, converted to dots per inch. PNGs are read from their pHYs chunk
+// and other formats from their EXIF data, with JPEGs falling back to the
+// density in their JFIF segment.
+func ExtractDPI(filePath string) (int, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	return ExtractDPIFrom(file)
+}
+
+// ExtractDPIFrom is ExtractDPI for an image that is already open or in
+// memory.
+func ExtractDPIFrom(file io.ReadSeeker) (int, error) {
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(file, signature); err == nil && bytes.Equal(signature, pngSignature) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return 0, fmt.Errorf("failed to rewind file: %w", err)
+		}
+		return readPNGDPI(file)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to rewind file: %w", err)
+	}
+
+	dpi, exifErr := readEXIFDPI(file)
+	if exifErr == nil {
+		return dpi, nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to rewind file: %w", err)
+	}
+	if dpi, err := readJFIFDPI(file); err == nil {
+		return dpi, nil
+	}
+	return 0, exifErr
+}
+
+// readEXIFDPI returns the horizontal resolution in the EXIF data of r,
+// converted to dots per inch.
+func readEXIFDPI(r io.Reader) (int, error) {
+	e, err := exif.Decode(r)
+	if err != nil {
+		return 0, fmt.Errorf("no EXIF data or corrupted EXIF data: %w", err)
+	}
+
+	xResolution, err := e.Get(exif.XResolution)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get XResolution: %w", err)
+	}
+
+	xNum, xDen, err := xResolution.Rat2(0)
+	if err != nil {
+		return 0, fmt.Errorf("error reading XResolution: %w", err)
+	}
+
+	x := float64(xNum) / float64(xDen)
+
+	// ResolutionUnit 3 means the values are pixels per centimeter. Anything
+	// else, including a missing tag, is taken as the default of inches.
+	if unit, err := e.Get(exif.ResolutionUnit); err == nil {
+		if value, err := unit.Int(0); err == nil && value == 3 {
+			x *= 2.54
+		}
+	}
+
+	return int(math.Round(x)), nil
+}
+
+// ExtractOrientation returns the EXIF orientation (1-8) of the image at
+// filePath.
+func ExtractOrientation(filePath string) (int, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	return extractOrientation(file)
+}
+
+func extractOrientation(r io.Reader) (int, error) {
+	e, err := exif.Decode(r)
+	if err != nil {
+		return 0, fmt.Errorf("no EXIF data or corrupted EXIF data: %w", err)
+	}
+
+	orientation, err := e.Get(exif.Orientation)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get Orientation: %w", err)
+	}
+
+	value, err := orientation.Int(0)
+	if err != nil {
+		return 0, fmt.Errorf("error reading Orientation: %w", err)
+	}
+	return value, nil
+}
+
+// shapeImage applies an EXIF orientation and the rotation, flip, crop and
+// grayscale conversion in opts to img, in that order, ahead of resizing. It
+// reports whether the crop removed anything.
+func shapeImage(img image.Image, orientation int, opts *Options) (image.Image, bool, error) {
+	img = orientImage(img, orientation)
+	manual, err := manualOrientations(opts.Rotate, opts.Flip)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, step := range manual {
+		img = orientImage(img, step)
+	}
+
+	cropped := false
+	cropAspect := opts.CropAspect
+	if opts.Fit == "cover" {
+		cropAspect = float64(opts.MaxWidth) / float64(opts.MaxHeight)
+	}
+	if cropAspect > 0 {
+		before := img.Bounds()
+		img = cropToAspect(img, cropAspect, opts.CropGravity)
+		cropped = img.Bounds() != before
+	}
+
+	if opts.Grayscale {
+		img = toGray(img, opts.Background)
+	}
+	return img, cropped, nil
+}
+
+// scaleImage resizes img to width x height. The "contain" fit scales it to
+// fit inside that box instead and pads the rest with the background.
+func scaleImage(img image.Image, width, height int, opts *Options) image.Image {
+	if opts.Fit != "contain" {
+		return resize.Resize(uint(width), uint(height), img, opts.Algorithm)
+	}
+	bounds := img.Bounds()
+	innerWidth, innerHeight := CalculateMaxDimensions(bounds.Dx(), bounds.Dy(), width, height, opts.AllowUpscale)
+	inner := resize.Resize(uint(innerWidth), uint(innerHeight), img, opts.Algorithm)
+	return padToSize(inner, width, height, opts.Background)
+}
+
+// dpiNote describes the DPI recorded in an output for log messages.
+func dpiNote(dpi int) string {
+	if dpi <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" with a DPI of %d", dpi)
+}
+
+// needsResize reports whether the target size differs from the original in a
+// direction the options allow. Fit "contain" pads images smaller than the box
+// instead of enlarging them, so it always reaches the box.
+func needsResize(originalWidth, originalHeight, newWidth, newHeight int, opts *Options) bool {
+	if opts.AllowUpscale || opts.Fit == "contain" {
+		return newWidth != originalWidth || newHeight != originalHeight
+	}
+	return newWidth < originalWidth || newHeight < originalHeight
+}
+
+// ResizeImage resizes the image at filePath according to opts and writes it
+// to the path outputPathFor returns. dpi is the source resolution, from which
+// the resolution recorded for the output is computed. Zero means the source
+// records none: it is sized as if it had DefaultDPI, and the output records
+// no resolution unless Options.TargetDPI asks for one. Cancelling ctx stops
+// the work before the next decode or write. Outputs are written to a
+// temporary file and renamed into place, so they are never left half-written.
+func ResizeImage(ctx context.Context, filePath string, outputPathFor OutputPathFunc, dpi int, opts *Options) (Result, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	return resizeFile(ctx, file, filePath, outputPathFor, dpi, opts, func(outputPath string, encode func(w io.Writer) error) error {
+		if err := writeOutput(outputPath, encode); err != nil {
+			return err
+		}
+		if opts.PreserveModTime {
+			if err := os.Chtimes(outputPath, time.Time{}, info.ModTime()); err != nil {
+				return fmt.Errorf("failed to set modification time: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// WriteFunc stores an encoded image somewhere other than the file system.
+// outputPath is the value returned by the OutputPathFunc, and encode writes
+// the encoded image to the writer it is given.
+type WriteFunc func(outputPath string, encode func(w io.Writer) error) error
+
+// ResizeImageTo is ResizeImage with the output passed to write instead of
+// being saved to a file, for destinations such as archives.
+func ResizeImageTo(ctx context.Context, filePath string, outputPathFor OutputPathFunc, dpi int, opts *Options, write WriteFunc) (Result, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	return resizeFile(ctx, file, filePath, outputPathFor, dpi, opts, write)
+}
+
+// resizeFile runs resizeSource on an open file, closing it before write is
+// called so in-place output can replace it.
+func resizeFile(ctx context.Context, file *os.File, filePath string, outputPathFor OutputPathFunc, dpi int, opts *Options, write WriteFunc) (Result, error) {
+	return resizeSource(ctx, file, filePath, outputPathFor, dpi, opts, func(outputPath string, encode encodeFunc) error {
+		file.Close()
+		return write(outputPath, encode)
+	})
+}
+
+// ResizeReader is ResizeImage for an image that is already in memory or
+// otherwise not a local file, such as a download. name identifies it in log
+// messages.
+func ResizeReader(ctx context.Context, src io.ReadSeeker, name string, outputPathFor OutputPathFunc, dpi int, opts *Options) (Result, error) {
+	return resizeSource(ctx, src, name, outputPathFor, dpi, opts, func(outputPath string, encode encodeFunc) error {
+		return writeOutput(outputPath, encode)
+	})
+}
+
+// ResizeReaderTo is ResizeReader with the output passed to write instead of
+// being saved to a file.
+func ResizeReaderTo(ctx context.Context, src io.ReadSeeker, name string, outputPathFor OutputPathFunc, dpi int, opts *Options, write WriteFunc) (Result, error) {
+	return resizeSource(ctx, src, name, outputPathFor, dpi, opts, func(outputPath string, encode encodeFunc) error {
+		return write(outputPath, encode)
+	})
+}
+
+// Resize reads an image from r and writes the resized image to w, in
+// opts.Format or the source format when that is empty. Nothing is written
+// when the result is skipped because the image is already within the limits.
+// Readers that cannot seek are buffered in memory.
+func Resize(ctx context.Context, r io.Reader, w io.Writer, dpi int, opts *Options) (Result, error) {
+	src, ok := r.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to read image: %w", err)
+		}
+		src = bytes.NewReader(data)
+	}
+
+	return resizeSource(ctx, src, "image", nil, dpi, opts, func(_ string, encode encodeFunc) error {
+		return encode(w)
+	})
+}
+
+var (
+	// ErrEmptyFile is returned for sources with no data at all, which are
+	// usually left behind by failed downloads or copies.
+	ErrEmptyFile = errors.New("file is empty (0 bytes), probably left by a failed download or copy")
+	// ErrTruncated is returned when the image data ends before the image
+	// is complete.
+	ErrTruncated = errors.New("image data ends early; the file is probably incomplete")
+	// ErrTooManyPixels is returned, before decoding, for images whose header
+	// declares more than Options.MaxPixels pixels.
+	ErrTooManyPixels = errors.New("image is too large to decode")
+)
+
+// encodeFunc writes an encoded image to w.
+type encodeFunc func(w io.Writer) error
+
+// resizeSource does the work of ResizeImage and Resize. name identifies the
+// source in log messages. outputPathFor may be nil when there is no output
+// path, and write delivers the encoded result.
+func resizeSource(ctx context.Context, src io.ReadSeeker, name string, outputPathFor OutputPathFunc, dpi int, opts *Options, write func(outputPath string, encode encodeFunc) error) (Result, error) {
+	var result Result
+	log := opts.logger()
+
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	if size, err := src.Seek(0, io.SeekEnd); err == nil {
+		result.SourceBytes = size
+		if size == 0 {
+			return result, ErrEmptyFile
+		}
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return result, fmt.Errorf("failed to rewind file: %w", err)
+	}
+
+	// Read just the header first; most of an already-compliant archive can be
+	// skipped without paying for a full decode.
+	config, format, err := image.DecodeConfig(src)
+	if err != nil {
+		return result, decodeError(err, src, "", result.SourceBytes)
+	}
+	if pixels := int64(config.Width) * int64(config.Height); opts.MaxPixels > 0 && pixels > opts.MaxPixels {
+		return result, fmt.Errorf("%w: %dx%d is %d pixels, over the limit of %d", ErrTooManyPixels, config.Width, config.Height, pixels, opts.MaxPixels)
+	}
+
+	orientation := 1
+	if opts.AutoOrient {
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return result, fmt.Errorf("failed to rewind file: %w", err)
+		}
+		if value, err := extractOrientation(src); err == nil && value > 1 {
+			orientation = value
+		}
+	}
+
+	// Sizing needs a resolution even when the source records none.
+	sizingDPI := dpi
+	if sizingDPI <= 0 {
+		sizingDPI = DefaultDPI
+	}
+
+	outputFormat := OutputFormatFor(format)
+	if opts.Format != "" {
+		outputFormat = opts.Format
+	}
+	result.Format = outputFormat
+
+	// A file over the target size is worth re-encoding even at its size.
+	oversized := opts.TargetSize > 0 && result.SourceBytes > opts.TargetSize && hasQualitySetting(outputFormat, opts)
+	// Outputs that only shrink the image are expected to be smaller files.
+	checkSize := opts.SkipIfLarger || (!opts.KeepLarger && outputFormat == format && !opts.altersPixels())
+
+	if opts.CropAspect == 0 && opts.Fit != "cover" && outputFormat == format && !opts.altersPixels() && !oversized {
+		width, height := config.Width, config.Height
+		// Orientations 5-8 are stored sideways.
+		if orientation >= 5 {
+			width, height = height, width
+		}
+		newWidth, newHeight, err := CalculateTargetResolution(width, height, getModelPixelFormat(config.ColorModel), sizingDPI, opts)
+		if err != nil {
+			return result, err
+		}
+		if !needsResize(width, height, newWidth, newHeight, opts) {
+			log.Info(fmt.Sprintf("Left %s unchanged: %dx%d is already within the size limits", name, width, height))
+			result.OriginalW, result.OriginalH = width, height
+			result.Skipped = true
+			return result, nil
+		}
+		if bits, ok := looksOptimized(format, result.SourceBytes, width, height); ok {
+			if opts.SkipOptimized {
+				log.Info(fmt.Sprintf("Left %s unchanged: at %.2f bits per pixel it looks already optimized", name, bits))
+				result.OriginalW, result.OriginalH = width, height
+				result.Skipped = true
+				return result, nil
+			}
+			log.Info(fmt.Sprintf("%s looks already optimized at %.2f bits per pixel; re-encoding it may lose quality for little gain", name, bits))
+		}
+	}
+
+	if opts.Budget != nil {
+		bytesPerPixel, err := GetBytesPerPixel(getModelPixelFormat(config.ColorModel))
+		if err != nil {
+			return result, err
+		}
+		decodedBytes := int64(config.Width) * int64(config.Height) * int64(bytesPerPixel)
+		reserved, err := opts.Budget.acquire(ctx, decodedBytes)
+		if err != nil {
+			return result, err
+		}
+		defer opts.Budget.release(reserved)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return result, fmt.Errorf("failed to rewind file: %w", err)
+	}
+
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return result, decodeError(err, src, format, result.SourceBytes)
+	}
+	if cmyk, ok := img.(*image.CMYK); ok {
+		log.Debug(fmt.Sprintf("Converting %s from CMYK to RGB", name))
+		img = cmykToRGBA(cmyk)
+	}
+
+	// Orientation to write into preserved EXIF; zero leaves the tag as it was.
+	exifOrientation := 0
+	if orientation > 1 {
+		exifOrientation = 1
+	}
+	img, cropped, err := shapeImage(img, orientation, opts)
+	if err != nil {
+		return result, err
+	}
+
+	originalWidth, originalHeight := img.Bounds().Dx(), img.Bounds().Dy()
+	result.OriginalW, result.OriginalH = originalWidth, originalHeight
+	pixelFormat := getDecodedPixelFormat(img)
+	newWidth, newHeight, err := CalculateTargetResolution(originalWidth, originalHeight, pixelFormat, sizingDPI, opts)
+	if err != nil {
+		return result, err
+	}
+
+	if !needsResize(originalWidth, originalHeight, newWidth, newHeight, opts) {
+		// Cropping, transforming or converting to another format is worth
+		// doing even at the original size.
+		if outputFormat == format && !cropped && !opts.altersPixels() && !oversized {
+			log.Info(fmt.Sprintf("Left %s unchanged: %dx%d is already within the size limits", name, originalWidth, originalHeight))
+			result.Skipped = true
+			return result, nil
+		}
+		newWidth, newHeight = originalWidth, originalHeight
+	}
+	// Enlarged or padded outputs hold more pixels, so a larger file is
+	// expected of them.
+	if !opts.SkipIfLarger && int64(newWidth)*int64(newHeight) > int64(originalWidth)*int64(originalHeight) {
+		checkSize = false
+	}
+
+	// The output keeps the source's print size, so its DPI scales with the
+	// width of the image, which for "contain" is the part inside the padding.
+	// A source without a resolution gets none unless one was asked for.
+	contentWidth := newWidth
+	if opts.Fit == "contain" {
+		contentWidth, _ = CalculateMaxDimensions(originalWidth, originalHeight, newWidth, newHeight, opts.AllowUpscale)
+	}
+	newDPI := 0
+	if dpi > 0 || opts.TargetDPI > 0 {
+		newDPI = int(math.Round(float64(contentWidth) * float64(sizingDPI) / float64(originalWidth)))
+	}
+	result.NewW, result.NewH, result.DPI = newWidth, newHeight, newDPI
+
+	var outputPath string
+	if outputPathFor != nil {
+		outputPath = outputPathFor(newWidth, newHeight, newDPI)
+		if outputPath == "" {
+			result.Skipped = true
+			return result, nil
+		}
+		result.OutputPath = outputPath
+	}
+
+	if opts.DryRun {
+		bytesPerPixel, err := GetBytesPerPixel(pixelFormat)
+		if err != nil {
+			return result, err
+		}
+		bitmapSize := int64(newWidth) * int64(bytesPerPixel) * int64(newHeight)
+		result.EstimatedBytes = estimateOutputBytes(newWidth, newHeight, outputFormat, result.SourceBytes, int64(config.Width)*int64(config.Height), format, bytesPerPixel, opts)
+		log.Info(fmt.Sprintf("Would resize %s from %dx%d to %dx%d%s and save to %s (%d bytes uncompressed, about %d bytes encoded versus %d for the source)", name, originalWidth, originalHeight, newWidth, newHeight, dpiNote(newDPI), outputPath, bitmapSize, result.EstimatedBytes, result.SourceBytes))
+		return result, nil
+	}
+
+	var anim *gif.GIF
+	if format == "gif" && opts.GIFAllFrames {
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return result, fmt.Errorf("failed to rewind file: %w", err)
+		}
+		anim, err = gif.DecodeAll(src)
+		if err != nil {
+			return result, fmt.Errorf("failed to decode GIF frames: %w", err)
+		}
+	}
+
+	meta := Metadata{DPI: newDPI}
+	if opts.PreserveEXIF && format == "jpeg" && outputFormat == "jpeg" {
+		if _, err = src.Seek(0, io.SeekStart); err == nil {
+			meta.EXIF, err = readEXIFSegment(src)
+		}
+		if err == nil && meta.EXIF != nil {
+			err = patchEXIF(meta.EXIF, exifOrientation, newDPI)
+		}
+		if err != nil {
+			log.Warn(fmt.Sprintf("Failed to preserve EXIF for %s: %v", name, err))
+			meta.EXIF = nil
+		}
+	}
+	// A color profile does not describe grayscale output, so it is dropped.
+	if opts.PreserveICC && !opts.Grayscale && (format == "jpeg" || format == "png") {
+		if _, err = src.Seek(0, io.SeekStart); err == nil {
+			meta.ICCProfile, err = readICCProfile(src, format)
+		}
+		if err != nil {
+			log.Warn(fmt.Sprintf("Failed to preserve the color profile of %s: %v", name, err))
+			meta.ICCProfile = nil
+		}
+		// The pixels are converted to RGB, which a CMYK profile does not
+		// describe.
+		if iccColorSpace(meta.ICCProfile) == "CMYK" {
+			log.Warn(fmt.Sprintf("The CMYK color profile of %s does not apply to the RGB output and was dropped", name))
+			meta.ICCProfile = nil
+		}
+		if meta.ICCProfile != nil && outputFormat != "jpeg" && outputFormat != "png" {
+			log.Warn(fmt.Sprintf("The color profile of %s cannot be embedded in %s output and was dropped", name, outputFormat))
+			meta.ICCProfile = nil
+		}
+	}
+
+	if anim != nil && len(anim.Image) > 1 && outputFormat == "gif" {
+		if opts.Watermark != nil || opts.Caption != nil {
+			log.Warn(fmt.Sprintf("Watermarks and captions are not applied to animated GIFs; %s was resized without them", name))
+		}
+		resized, err := resizeAnimatedGIF(anim, newWidth, newHeight, opts.Grayscale, opts.Dither, func(frame image.Image) (image.Image, error) {
+			frame, _, err := shapeImage(frame, orientation, opts)
+			if err != nil {
+				return nil, err
+			}
+			return scaleImage(frame, newWidth, newHeight, opts), nil
+		})
+		if err != nil {
+			return result, err
+		}
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		log.Info(fmt.Sprintf("Resized %d frames of %s to %dx%d%s", len(resized.Image), name, newWidth, newHeight, dpiNote(newDPI)))
+		return result, writeUnlessLarger(write, outputPath, &result, name, checkSize, log, func(w io.Writer) error {
+			if err := gif.EncodeAll(w, resized); err != nil {
+				return fmt.Errorf("failed to encode GIF: %w", err)
+			}
+			return nil
+		})
+	}
+
+	resized := scaleImage(img, newWidth, newHeight, opts)
+	if opts.Watermark != nil {
+		resized, err = applyWatermark(resized, opts.Watermark, opts.Algorithm)
+		if err != nil {
+			return result, err
+		}
+	}
+	if opts.Caption != nil {
+		resized, err = applyCaption(resized, opts.Caption, name)
+		if err != nil {
+			return result, err
+		}
+	}
+	if opts.Grayscale && (opts.Watermark != nil || opts.Caption != nil) {
+		// Overlays are drawn in color; keep the output gray.
+		resized = toGray(resized, opts.Background)
+	}
+	if opts.RegenerateThumbnail && meta.EXIF != nil {
+		if exifData, err := regenerateEXIFThumbnail(meta.EXIF, resized, opts.Algorithm); err != nil {
+			log.Warn(fmt.Sprintf("Kept the old EXIF thumbnail of %s: %v", name, err))
+		} else {
+			meta.EXIF = exifData
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	log.Info(fmt.Sprintf("Resized %s to %dx%d%s", name, newWidth, newHeight, dpiNote(newDPI)))
+
+	if opts.TargetSize > 0 && hasQualitySetting(outputFormat, opts) {
+		data, quality, met, err := encodeToSize(resized, outputFormat, meta, opts)
+		if err != nil {
+			return result, err
+		}
+		result.Quality = quality
+		if met {
+			log.Info(fmt.Sprintf("Chose quality %d for %s: %d bytes, within the target of %d", quality, name, len(data), opts.TargetSize))
+		} else {
+			log.Warn(fmt.Sprintf("%s is %d bytes even at quality %d, over the target of %d", name, len(data), quality, opts.TargetSize))
+		}
+		return result, writeUnlessLarger(write, outputPath, &result, name, checkSize, log, func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		})
+	}
+
+	if opts.MinSSIM > 0 && hasQualitySetting(outputFormat, opts) {
+		if !canMeasureSSIM(outputFormat, opts) {
+			log.Warn(fmt.Sprintf("Cannot measure the similarity of %s output; encoded %s at quality %d", strings.ToUpper(outputFormat), name, opts.qualityFor(outputFormat)))
+		} else {
+			data, quality, similarity, met, err := encodeToSSIM(resized, outputFormat, meta, opts)
+			if err != nil {
+				return result, err
+			}
+			result.Quality, result.SSIM = quality, similarity
+			if met {
+				log.Info(fmt.Sprintf("Chose quality %d for %s: SSIM %.4f, at least %.4f", quality, name, similarity, opts.MinSSIM))
+			} else {
+				log.Warn(fmt.Sprintf("%s only reaches SSIM %.4f at quality %d, below %.4f; raise --quality to allow more", name, similarity, quality, opts.MinSSIM))
+			}
+			return result, writeUnlessLarger(write, outputPath, &result, name, checkSize, log, func(w io.Writer) error {
+				_, err := w.Write(data)
+				return err
+			})
+		}
+	}
+
+	return result, writeUnlessLarger(write, outputPath, &result, name, checkSize, log, func(w io.Writer) error {
+		return EncodeImage(w, resized, outputFormat, meta, opts)
+	})
+}
+
+// writeUnlessLarger passes encode to write. With check set the image is
+// encoded in memory first and dropped, marking result as skipped, if it is
+// not smaller than the source.
+func writeUnlessLarger(write func(string, encodeFunc) error, outputPath string, result *Result, name string, check bool, log Logger, encode encodeFunc) error {
+	if !check || result.SourceBytes <= 0 {
+		return writeCounted(write, outputPath, result, encode)
+	}
+
+	var buf bytes.Buffer
+	if err := encode(&buf); err != nil {
+		return err
+	}
+	if int64(buf.Len()) >= result.SourceBytes {
+		log.Info(fmt.Sprintf("Kept %s as it was: the resized version is %d bytes, not smaller than the original %d", name, buf.Len(), result.SourceBytes))
+		result.Skipped, result.Larger = true, true
+		result.OutputPath = ""
+		return nil
+	}
+	return writeCounted(write, outputPath, result, func(w io.Writer) error {
+		_, err := w.Write(buf.Bytes())
+		return err
+	})
+}
+
+// writeCounted passes encode to write and records the number of bytes it
+// produced in result.OutputBytes.
+func writeCounted(write func(string, encodeFunc) error, outputPath string, result *Result, encode encodeFunc) error {
+	return write(outputPath, func(w io.Writer) error {
+		counter := &countingWriter{w: w}
+		err := encode(counter)
+		result.OutputBytes = counter.n
+		return err
+	})
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Metadata is carried from a source image into its encoded output.
+type Metadata struct {
+	// EXIF is an APP1 payload embedded in JPEG output.
+	EXIF []byte
+	// ICCProfile is a color profile embedded in JPEG and PNG output.
+	ICCProfile []byte
+	// DPI is recorded as the pixel density of JPEG and PNG output. Zero
+	// records nothing.
+	DPI int
+}
+
+// SaveImage encodes img in format ("png", "jpeg", "webp", "gif", "tiff" or
+// "bmp") with meta embedded where the format allows and writes it to
+// outputPath.
+func SaveImage(img image.Image, outputPath, format string, meta Metadata, opts *Options) error {
+	return writeOutput(outputPath, func(w io.Writer) error {
+		return EncodeImage(w, img, format, meta, opts)
+	})
+}
+
+// writeOutput creates outputPath and passes it to write. The data is written
+// to a temporary file in the same directory and renamed to outputPath only
+// once write succeeds, so outputs are always either complete or absent.
+func writeOutput(outputPath string, write func(w io.Writer) error) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(outputPath), "."+filepath.Base(outputPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tempPath := tempFile.Name()
+
+	// Temporary files are private; keep the permissions of the file being
+	// replaced, or use the usual permissions for a new file.
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(outputPath); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tempFile.Chmod(mode)
+
+	if err = write(tempFile); err != nil {
+		tempFile.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err = tempFile.Close(); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err = os.Rename(tempPath, outputPath); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to replace output file: %w", err)
+	}
+	return nil
+}
+
+// EncodeImage encodes img in format and writes it to w, with meta embedded
+// where the format allows.
+func EncodeImage(w io.Writer, img image.Image, format string, meta Metadata, opts *Options) error {
+	var err error
+	switch format {
+	case "png":
+		encoder := png.Encoder{CompressionLevel: opts.PNGCompression}
+
+		var chunks []pngChunk
+		if meta.DPI > 0 {
+			chunks = append(chunks, physChunk(meta.DPI))
+		}
+		if meta.ICCProfile != nil {
+			iccp, err := iccpChunk(meta.ICCProfile)
+			if err != nil {
+				return fmt.Errorf("failed to compress ICC profile: %w", err)
+			}
+			chunks = append(chunks, pngChunk{chunkType: "iCCP", data: iccp})
+		}
+		if chunks == nil {
+			if err = encoder.Encode(w, img); err != nil {
+				return fmt.Errorf("failed to encode PNG: %w", err)
+			}
+			return nil
+		}
+
+		var buf bytes.Buffer
+		if err = encoder.Encode(&buf, img); err != nil {
+			return fmt.Errorf("failed to encode PNG: %w", err)
+		}
+		if err = insertPNGChunks(w, buf.Bytes(), chunks); err != nil {
+			return fmt.Errorf("failed to write PNG: %w", err)
+		}
+	case "jpeg":
+		// JPEG has no alpha channel, so composite transparent images first.
+		img = flattenImage(img, opts.Background)
+
+		var segments []jpegSegment
+		if meta.DPI > 0 {
+			// JFIF must be the first segment after SOI.
+			segments = append(segments, jfifSegment(meta.DPI))
+		}
+		if meta.EXIF != nil {
+			segments = append(segments, jpegSegment{marker: markerAPP1, payload: meta.EXIF})
+		}
+		if meta.ICCProfile != nil {
+			segments = append(segments, iccSegments(meta.ICCProfile)...)
+		}
+		encode := func(w io.Writer) error {
+			if needsLibJPEG(opts) {
+				return encodeLibJPEG(w, img, opts.qualityFor(format), opts.Progressive, opts.Subsampling)
+			}
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: opts.qualityFor(format)})
+		}
+		if segments == nil {
+			if err = encode(w); err != nil {
+				return fmt.Errorf("failed to encode JPEG: %w", err)
+			}
+			return nil
+		}
+
+		var buf bytes.Buffer
+		if err = encode(&buf); err != nil {
+			return fmt.Errorf("failed to encode JPEG: %w", err)
+		}
+		if err = insertJPEGSegments(w, buf.Bytes(), segments); err != nil {
+			return fmt.Errorf("failed to write JPEG: %w", err)
+		}
+	case "webp":
+		if err = encodeWebP(w, img, opts.qualityFor(format), opts.Lossless); err != nil {
+			return fmt.Errorf("failed to encode WebP: %w", err)
+		}
+	case "avif":
+		if err = encodeAVIF(w, img, opts.qualityFor(format)); err != nil {
+			return fmt.Errorf("failed to encode AVIF: %w", err)
+		}
+	case "gif":
+		if err = gif.Encode(w, quantizeImage(img, opts.Dither), nil); err != nil {
+			return fmt.Errorf("failed to encode GIF: %w", err)
+		}
+	case "tiff":
+		if err = tiff.Encode(w, img, &tiff.Options{Compression: opts.TIFFCompression, Predictor: opts.TIFFCompression == tiff.Deflate}); err != nil {
+			return fmt.Errorf("failed to encode TIFF: %w", err)
+		}
+	case "bmp":
+		if err = bmp.Encode(w, img); err != nil {
+			return fmt.Errorf("failed to encode BMP: %w", err)
+		}
+
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+
+	return nil
+}
+
+// GetResizeAlgorithm maps an algorithm name such as "lanczos" or "bilinear"
+// to its interpolation function.
+func GetResizeAlgorithm(name string) (resize.InterpolationFunction, error) {
+	switch strings.ToLower(name) {
+	case "bilinear":
+		return resize.Bilinear, nil
+	case "nearest":
+		return resize.NearestNeighbor, nil
+	case "bicubic", "catmullrom":
+		return resize.Bicubic, nil
+	case "mitchell", "mitchellnetravali":
+		return resize.MitchellNetravali, nil
+	case "lanczos2":
+		return resize.Lanczos2, nil
+	case "lanczos", "lanczos3":
+		return resize.Lanczos3, nil
+	default:
+		return 0, fmt.Errorf("unknown algorithm %q (valid algorithms: lanczos, lanczos2, bicubic, mitchell, bilinear, nearest)", name)
+	}
+}
+
+// NormalizeFormat maps a format name or extension such as "jpg" or ".tif" to
+// the canonical format name used by Options.Format.
+func NormalizeFormat(name string) (string, error) {
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "png":
+		return "png", nil
+	case "jpg", "jpeg":
+		return "jpeg", nil
+	case "webp":
+		return "webp", nil
+	case "gif":
+		return "gif", nil
+	case "tif", "tiff":
+		return "tiff", nil
+	case "bmp":
+		return "bmp", nil
+	case "avif":
+		return "avif", nil
+	default:
+		return "", fmt.Errorf("unsupported output format %q (valid formats: png, jpeg, webp, avif, gif, tiff, bmp)", name)
+	}
+}
+
+// OutputFormatFor returns the format an image decoded as format is saved in
+// when Options.Format is empty. HEIC can be read but not written, so it is
+// saved as JPEG, and WebP is saved as PNG in builds without WebPEncoding.
+func OutputFormatFor(format string) string {
+	switch {
+	case format == "heic":
+		return "jpeg"
+	case format == "webp" && !WebPEncoding:
+		return "png"
+	}
+	return format
+}
+
+// FormatExtension returns the file extension, including the dot, used for a
+// canonical format name.
+func FormatExtension(format string) string {
+	switch format {
+	case "jpeg":
+		return ".jpg"
+	default:
+		return "." + format
+	}
+}
@@ -0,0 +1,199 @@
+package resizer
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/nfnt/resize"
+)
+
+// bitmapBytes is the memory CalculateMaxResolution budgets for a bitmap.
+func bitmapBytes(width, height, bytesPerPixel, alignment int) int64 {
+	stride := (width*bytesPerPixel + alignment - 1) / alignment * alignment
+	return int64(stride) * int64(height)
+}
+
+func TestCalculateMaxResolutionTerminates(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		limit         int64
+	}{
+		{"one byte for a wide strip", 10000, 1, 1},
+		{"one byte for a tall strip", 1, 10000, 1},
+		{"one byte for a square", 4000, 3000, 1},
+		{"a few rows of a wide strip", 10000, 1, 100},
+		{"a panorama", 100000, 10, 1 << 20},
+	}
+	for _, tt := range tests {
+		type size struct {
+			width, height int
+			err           error
+		}
+		done := make(chan size, 1)
+		go func() {
+			w, h, err := CalculateMaxResolution(tt.width, tt.height, Format24bppRgb, 4, tt.limit, 0)
+			done <- size{w, h, err}
+		}()
+
+		select {
+		case got := <-done:
+			if got.err != nil {
+				t.Errorf("%s: %v", tt.name, got.err)
+				continue
+			}
+			if got.width < 1 || got.height < 1 {
+				t.Errorf("%s: returned %dx%d", tt.name, got.width, got.height)
+			}
+			// Only the documented 1x1 floor may exceed the limit.
+			if used := bitmapBytes(got.width, got.height, 3, 4); used > tt.limit && (got.width != 1 || got.height != 1) {
+				t.Errorf("%s: %dx%d needs %d bytes, over the limit of %d", tt.name, got.width, got.height, used, tt.limit)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: CalculateMaxResolution did not return", tt.name)
+		}
+	}
+}
+
+func TestCalculateMaxResolutionOneByteWideStrip(t *testing.T) {
+	w, h, err := CalculateMaxResolution(10000, 1, Format24bppRgb, 4, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != 1 || h != 1 {
+		t.Errorf("got %dx%d, want the 1x1 floor", w, h)
+	}
+}
+
+func TestBytesPerPixelEstimate(t *testing.T) {
+	rect := image.Rect(0, 0, 2, 2)
+	tests := []struct {
+		name  string
+		img   image.Image
+		model color.Model
+		want  int
+	}{
+		{"paletted", image.NewPaletted(rect, color.Palette{color.Black, color.White}), color.Palette{color.Black, color.White}, 1},
+		{"gray", image.NewGray(rect), color.GrayModel, 1},
+		{"alpha", image.NewAlpha(rect), color.AlphaModel, 1},
+		{"gray 16-bit", image.NewGray16(rect), color.Gray16Model, 2},
+		{"alpha 16-bit", image.NewAlpha16(rect), color.Alpha16Model, 2},
+		{"YCbCr", image.NewYCbCr(rect, image.YCbCrSubsampleRatio420), color.YCbCrModel, 3},
+		{"CMYK", image.NewCMYK(rect), color.CMYKModel, 4},
+		{"RGBA", image.NewRGBA(rect), color.RGBAModel, 4},
+		{"NRGBA", image.NewNRGBA(rect), color.NRGBAModel, 4},
+		{"RGBA 16-bit", image.NewRGBA64(rect), color.RGBA64Model, 8},
+		{"NRGBA 16-bit", image.NewNRGBA64(rect), color.NRGBA64Model, 8},
+	}
+	for _, tt := range tests {
+		// Headers are budgeted from the color model before decoding, and
+		// decoded images from their concrete type; both must agree.
+		fromModel, err := GetBytesPerPixel(getModelPixelFormat(tt.model))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		fromImage, err := GetBytesPerPixel(getDecodedPixelFormat(tt.img))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if fromModel != tt.want || fromImage != tt.want {
+			t.Errorf("%s: %d bytes per pixel from the model and %d from the image, want %d", tt.name, fromModel, fromImage, tt.want)
+		}
+	}
+}
+
+func TestGetBytesPerPixelRejectsUnknownFormats(t *testing.T) {
+	if _, err := GetBytesPerPixel(PixelFormat(-1)); err == nil {
+		t.Error("expected an error for an unknown pixel format")
+	}
+}
+
+func TestCalculateMaxResolutionUsesBytesPerPixel(t *testing.T) {
+	const limit = 1 << 20
+	for _, format := range []PixelFormat{Format8bppGrayscale, Format16bppGrayscale, Format24bppRgb, Format32bppArgb, Format64bppArgb} {
+		bytesPerPixel, _ := GetBytesPerPixel(format)
+		w, h, err := CalculateMaxResolution(4000, 3000, format, 4, limit, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		used := bitmapBytes(w, h, bytesPerPixel, 4)
+		// The search lands close to the limit without going over it.
+		if used > limit || used < limit*9/10 {
+			t.Errorf("%d bytes per pixel: %dx%d uses %d bytes of %d", bytesPerPixel, w, h, used, limit)
+		}
+	}
+}
+
+func TestFitWithSmallSources(t *testing.T) {
+	var src bytes.Buffer
+	if err := png.Encode(&src, solidImage(100, 50)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fit          string
+		allowUpscale bool
+		wantW, wantH int
+		skipped      bool
+	}{
+		{"contain", false, 400, 300, false},
+		{"contain", true, 400, 300, false},
+		{"cover", false, 67, 50, false},
+		{"cover", true, 400, 300, false},
+		{"stretch", false, 100, 50, true},
+		{"stretch", true, 400, 300, false},
+	}
+	for _, tt := range tests {
+		opts := &Options{Fit: tt.fit, MaxWidth: 400, MaxHeight: 300, AllowUpscale: tt.allowUpscale, Algorithm: resize.Bilinear}
+		var out bytes.Buffer
+		result, err := Resize(context.Background(), bytes.NewReader(src.Bytes()), &out, 0, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.fit, err)
+		}
+		if result.Skipped != tt.skipped {
+			t.Errorf("%s, upscale %v: skipped = %v, want %v", tt.fit, tt.allowUpscale, result.Skipped, tt.skipped)
+		}
+		if tt.skipped {
+			continue
+		}
+		img, err := png.Decode(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := img.Bounds().Size(); got != image.Pt(tt.wantW, tt.wantH) {
+			t.Errorf("%s, upscale %v: output is %v, want %dx%d", tt.fit, tt.allowUpscale, got, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestFitContainPadsWithoutEnlarging(t *testing.T) {
+	var src bytes.Buffer
+	if err := png.Encode(&src, solidImage(100, 50)); err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{Fit: "contain", MaxWidth: 400, MaxHeight: 300, Background: color.Black, Algorithm: resize.Bilinear}
+	var out bytes.Buffer
+	if _, err := Resize(context.Background(), bytes.NewReader(src.Bytes()), &out, 0, opts); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The 100x50 source sits unscaled in the middle of the box.
+	inside := color.RGBAModel.Convert(img.At(200, 150)).(color.RGBA)
+	if want := (color.RGBA{40, 120, 200, 255}); !closeRGBA(inside, want, 1) {
+		t.Errorf("center is %v, want the source color %v", inside, want)
+	}
+	for _, p := range []image.Point{{149, 150}, {250, 150}, {200, 124}, {200, 175}} {
+		if got := color.RGBAModel.Convert(img.At(p.X, p.Y)).(color.RGBA); !closeRGBA(got, color.RGBA{0, 0, 0, 255}, 1) {
+			t.Errorf("%v is %v, want the background just outside the 100x50 source", p, got)
+		}
+	}
+}
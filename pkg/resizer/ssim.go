@@ -0,0 +1,142 @@
+package resizer
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+)
+
+// DefaultMinSSIM is the structural similarity Options.MinSSIM is usually
+// set to; at 0.98 differences are very hard to see.
+const DefaultMinSSIM = 0.98
+
+// ssimWindow is the side of the square windows SSIM is averaged over, and
+// ssimStep the distance between them.
+const (
+	ssimWindow = 8
+	ssimStep   = 4
+)
+
+// canMeasureSSIM reports whether outputs in format can be decoded again to
+// compare them with the image they were encoded from.
+func canMeasureSSIM(format string, opts *Options) bool {
+	return format == "jpeg" || (format == "webp" && !opts.Lossless)
+}
+
+// encodeToSSIM encodes img at the lowest quality, up to the one set for
+// format, whose output keeps a structural similarity of at least
+// opts.MinSSIM to img. It returns the encoded data, the quality and SSIM it
+// was chosen with, and whether the threshold was met; when even the starting
+// quality falls short, that encoding is returned.
+func encodeToSSIM(img image.Image, format string, meta Metadata, opts *Options) ([]byte, int, float64, bool, error) {
+	// Outputs are compared with what the encoder is given, which for JPEG
+	// has transparency flattened.
+	reference := img
+	if format == "jpeg" {
+		reference = flattenImage(img, opts.Background)
+	}
+	referenceLuma := lumaPlane(reference)
+
+	encode := func(quality int) ([]byte, float64, error) {
+		attempt := *opts
+		attempt.Quality, attempt.FormatQuality = quality, nil
+		var buf bytes.Buffer
+		if err := EncodeImage(&buf, img, format, meta, &attempt); err != nil {
+			return nil, 0, err
+		}
+		decoded, _, err := image.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to decode the %s output to measure it: %w", format, err)
+		}
+		return buf.Bytes(), ssim(referenceLuma, lumaPlane(decoded)), nil
+	}
+
+	start := opts.qualityFor(format)
+	best, bestSSIM, err := encode(start)
+	if err != nil || bestSSIM < opts.MinSSIM {
+		return best, start, bestSSIM, false, err
+	}
+
+	// Search for the lowest quality that still passes; hi always passes,
+	// lo never does.
+	lo, hi := 0, start
+	for step := 0; step < maxQualitySearchSteps && hi-lo > 1; step++ {
+		quality := (lo + hi) / 2
+		data, similarity, err := encode(quality)
+		if err != nil {
+			return nil, 0, 0, false, err
+		}
+		if similarity >= opts.MinSSIM {
+			hi, best, bestSSIM = quality, data, similarity
+		} else {
+			lo = quality
+		}
+	}
+	return best, hi, bestSSIM, true, nil
+}
+
+// luma is an image's brightness, one value per pixel, which is what SSIM
+// compares.
+type luma struct {
+	width, height int
+	pix           []float64
+}
+
+func lumaPlane(img image.Image) luma {
+	bounds := img.Bounds()
+	plane := luma{width: bounds.Dx(), height: bounds.Dy(), pix: make([]float64, bounds.Dx()*bounds.Dy())}
+	i := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			plane.pix[i] = (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+			i++
+		}
+	}
+	return plane
+}
+
+// ssim returns the mean structural similarity of two luma planes of the same
+// size, from 1 for identical images down towards 0. Images smaller than one
+// window are compared as a single window.
+func ssim(a, b luma) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+	if a.width != b.width || a.height != b.height || len(a.pix) == 0 {
+		return 0
+	}
+
+	window := func(x0, y0, w, h int) float64 {
+		var sumA, sumB, sumAA, sumBB, sumAB float64
+		for y := y0; y < y0+h; y++ {
+			row := y * a.width
+			for x := x0; x < x0+w; x++ {
+				va, vb := a.pix[row+x], b.pix[row+x]
+				sumA += va
+				sumB += vb
+				sumAA += va * va
+				sumBB += vb * vb
+				sumAB += va * vb
+			}
+		}
+		n := float64(w * h)
+		meanA, meanB := sumA/n, sumB/n
+		varA := sumAA/n - meanA*meanA
+		varB := sumBB/n - meanB*meanB
+		cov := sumAB/n - meanA*meanB
+		return ((2*meanA*meanB + c1) * (2*cov + c2)) / ((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+	}
+
+	w, h := min(ssimWindow, a.width), min(ssimWindow, a.height)
+	var total float64
+	count := 0
+	for y := 0; y+h <= a.height; y += ssimStep {
+		for x := 0; x+w <= a.width; x += ssimStep {
+			total += window(x, y, w, h)
+			count++
+		}
+	}
+	return total / float64(count)
+}
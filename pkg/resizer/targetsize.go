@@ -0,0 +1,82 @@
+package resizer
+
+import (
+	"bytes"
+	"image"
+)
+
+// maxQualitySearchSteps bounds the encodes spent looking for a quality that
+// meets Options.TargetSize. Seven halvings cover the whole 1-100 range.
+const maxQualitySearchSteps = 7
+
+// hasQualitySetting reports whether Options.Quality affects format.
+func hasQualitySetting(format string, opts *Options) bool {
+	switch format {
+	case "jpeg", "avif":
+		return true
+	case "webp":
+		return !opts.Lossless
+	}
+	return false
+}
+
+// qualityFor returns the quality format is encoded with.
+func (opts *Options) qualityFor(format string) int {
+	if quality, ok := opts.FormatQuality[format]; ok {
+		return quality
+	}
+	return opts.Quality
+}
+
+// encodeToSize encodes img with the highest quality, up to the one set for
+// format, whose output is no larger than opts.TargetSize. It returns the
+// encoded data, the quality used, and whether the target was met; when even
+// the lowest quality is too large, that smallest encoding is returned.
+func encodeToSize(img image.Image, format string, meta Metadata, opts *Options) ([]byte, int, bool, error) {
+	encode := func(quality int) ([]byte, error) {
+		attempt := *opts
+		attempt.Quality, attempt.FormatQuality = quality, nil
+		var buf bytes.Buffer
+		if err := EncodeImage(&buf, img, format, meta, &attempt); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	start := opts.qualityFor(format)
+	best, err := encode(start)
+	if err != nil || int64(len(best)) <= opts.TargetSize {
+		return best, start, err == nil, err
+	}
+
+	// Search for the highest quality that fits; lo always fits once found,
+	// hi never does.
+	var fit []byte
+	lo, hi := 0, start
+	for step := 0; step < maxQualitySearchSteps && hi-lo > 1; step++ {
+		quality := (lo + hi) / 2
+		data, err := encode(quality)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if int64(len(data)) <= opts.TargetSize {
+			lo, fit = quality, data
+		} else {
+			hi, best = quality, data
+		}
+	}
+	if fit != nil {
+		return fit, lo, true, nil
+	}
+	if hi > 1 {
+		smallest, err := encode(1)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if int64(len(smallest)) <= opts.TargetSize {
+			return smallest, 1, true, nil
+		}
+		return smallest, 1, false, nil
+	}
+	return best, hi, false, nil
+}
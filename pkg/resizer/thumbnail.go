@@ -0,0 +1,133 @@
+package resizer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+
+	"github.com/nfnt/resize"
+)
+
+const (
+	tagCompression                 = 0x0103
+	tagJPEGInterchangeFormat       = 0x0201
+	tagJPEGInterchangeFormatLength = 0x0202
+
+	typeLong = 4
+
+	// compressionJPEG marks an IFD1 thumbnail stored as a JPEG stream.
+	compressionJPEG = 6
+)
+
+// exifThumbnailSize is the longest side of a regenerated EXIF thumbnail,
+// matching the 160x120 thumbnails cameras write.
+const exifThumbnailSize = 160
+
+// exifThumbnailQuality keeps regenerated thumbnails small enough to fit in
+// the APP1 segment alongside the rest of the EXIF data.
+const exifThumbnailQuality = 75
+
+// regenerateEXIFThumbnail returns payload with its embedded thumbnail
+// replaced by a scaled-down copy of img.
+func regenerateEXIFThumbnail(payload []byte, img image.Image, algorithm resize.InterpolationFunction) ([]byte, error) {
+	thumbnail := resize.Thumbnail(exifThumbnailSize, exifThumbnailSize, img, algorithm)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumbnail, &jpeg.Options{Quality: exifThumbnailQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return replaceEXIFThumbnail(payload, buf.Bytes())
+}
+
+// replaceEXIFThumbnail returns a copy of payload whose IFD1 points at thumb,
+// a JPEG stream. An old thumbnail at the end of the data is dropped; one
+// elsewhere is left in place but no longer referenced. Payloads without a
+// JPEG thumbnail get a new IFD1.
+func replaceEXIFThumbnail(payload []byte, thumb []byte) ([]byte, error) {
+	tiffData := append([]byte(nil), payload[len(exifHeader):]...)
+	if len(tiffData) < 8 {
+		return nil, errors.New("EXIF data too short")
+	}
+
+	var order binary.ByteOrder
+	switch string(tiffData[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("invalid EXIF byte order")
+	}
+
+	ifd0 := int(order.Uint32(tiffData[4:8]))
+	if ifd0+2 > len(tiffData) {
+		return nil, errors.New("EXIF IFD0 offset out of range")
+	}
+	next := ifd0 + 2 + int(order.Uint16(tiffData[ifd0:]))*12
+	if next+4 > len(tiffData) {
+		return nil, errors.New("EXIF IFD0 entry out of range")
+	}
+
+	// Find the offset and length entries of an existing JPEG thumbnail.
+	offsetEntry, lengthEntry := -1, -1
+	if ifd1 := int(order.Uint32(tiffData[next:])); ifd1 != 0 && ifd1+2 <= len(tiffData) {
+		count := int(order.Uint16(tiffData[ifd1:]))
+		for i := 0; i < count; i++ {
+			entry := ifd1 + 2 + i*12
+			if entry+12 > len(tiffData) {
+				return nil, errors.New("EXIF IFD1 entry out of range")
+			}
+			switch order.Uint16(tiffData[entry:]) {
+			case tagJPEGInterchangeFormat:
+				offsetEntry = entry
+			case tagJPEGInterchangeFormatLength:
+				lengthEntry = entry
+			}
+		}
+	}
+
+	if offsetEntry >= 0 && lengthEntry >= 0 {
+		oldOffset := int(order.Uint32(tiffData[offsetEntry+8:]))
+		oldLength := int(order.Uint32(tiffData[lengthEntry+8:]))
+		if oldOffset > offsetEntry && oldOffset+oldLength == len(tiffData) {
+			tiffData = tiffData[:oldOffset]
+		}
+		putLongEntry(order, tiffData[offsetEntry:], tagJPEGInterchangeFormat, uint32(len(tiffData)))
+		putLongEntry(order, tiffData[lengthEntry:], tagJPEGInterchangeFormatLength, uint32(len(thumb)))
+		tiffData = append(tiffData, thumb...)
+	} else {
+		// IFD offsets must be even.
+		if len(tiffData)%2 != 0 {
+			tiffData = append(tiffData, 0)
+		}
+		ifd1 := len(tiffData)
+		ifd := make([]byte, 2+3*12+4)
+		order.PutUint16(ifd, 3)
+		compression := ifd[2:]
+		order.PutUint16(compression, tagCompression)
+		order.PutUint16(compression[2:], typeShort)
+		order.PutUint32(compression[4:], 1)
+		order.PutUint16(compression[8:], compressionJPEG)
+		putLongEntry(order, ifd[14:], tagJPEGInterchangeFormat, uint32(ifd1+len(ifd)))
+		putLongEntry(order, ifd[26:], tagJPEGInterchangeFormatLength, uint32(len(thumb)))
+		order.PutUint32(tiffData[next:], uint32(ifd1))
+		tiffData = append(tiffData, ifd...)
+		tiffData = append(tiffData, thumb...)
+	}
+
+	result := append(append([]byte(nil), exifHeader...), tiffData...)
+	if len(result)+2 > 0xFFFF {
+		return nil, errors.New("EXIF data with the new thumbnail is too large for a JPEG segment")
+	}
+	return result, nil
+}
+
+// putLongEntry writes an IFD entry holding a single LONG value.
+func putLongEntry(order binary.ByteOrder, entry []byte, tag uint16, value uint32) {
+	order.PutUint16(entry, tag)
+	order.PutUint16(entry[2:], typeLong)
+	order.PutUint32(entry[4:], 1)
+	order.PutUint32(entry[8:], value)
+}
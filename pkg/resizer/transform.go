@@ -0,0 +1,250 @@
+package resizer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// orientImage returns img transformed according to an EXIF orientation value
+// (1-8) so that it displays upright without relying on the tag. Orientations
+// 5-8 swap the width and height.
+func orientImage(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	src, bytesPerPixel := toPixelBuffer(img)
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst, _ := newPixelBuffer(image.Rect(0, 0, dstW, dstH), bytesPerPixel)
+
+	srcPix, srcStride, srcOK := pixelData(src)
+	dstPix, dstStride, dstOK := pixelData(dst)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // flip horizontal
+				dx, dy = w-1-x, y
+			case 3: // rotate 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // flip vertical
+				dx, dy = x, h-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotate 90 counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			if !srcOK || !dstOK {
+				dst.Set(dx, dy, src.At(x, y))
+				continue
+			}
+			s := y*srcStride + x*bytesPerPixel
+			d := dy*dstStride + dx*bytesPerPixel
+			copy(dstPix[d:d+bytesPerPixel], srcPix[s:s+bytesPerPixel])
+		}
+	}
+
+	return dst
+}
+
+// manualOrientations returns the EXIF orientation values that apply a
+// clockwise rotation in degrees followed by a flip, in order.
+func manualOrientations(rotate int, flip string) ([]int, error) {
+	var steps []int
+	switch rotate {
+	case 0:
+	case 90:
+		steps = append(steps, 6)
+	case 180:
+		steps = append(steps, 3)
+	case 270:
+		steps = append(steps, 8)
+	default:
+		return nil, fmt.Errorf("invalid rotation %d (valid values: 90, 180, 270)", rotate)
+	}
+	switch flip {
+	case "":
+	case "horizontal":
+		steps = append(steps, 2)
+	case "vertical":
+		steps = append(steps, 4)
+	default:
+		return nil, fmt.Errorf("invalid flip %q (valid values: horizontal, vertical)", flip)
+	}
+	return steps, nil
+}
+
+// cmykToRGBA converts a CMYK image, as decoded from print-workflow JPEGs, to
+// RGB. image/jpeg has already undone the inverted storage of Adobe CMYK
+// files, so the standard conversion applies. Without this the resizer treats
+// the image generically and produces a 16-bit result at twice the memory.
+func cmykToRGBA(img *image.CMYK) *image.RGBA {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		src := img.Pix[img.PixOffset(bounds.Min.X, y):]
+		row := dst.Pix[(y-bounds.Min.Y)*dst.Stride:]
+		for x := 0; x < bounds.Dx(); x++ {
+			r, g, b := color.CMYKToRGB(src[x*4], src[x*4+1], src[x*4+2], src[x*4+3])
+			row[x*4], row[x*4+1], row[x*4+2], row[x*4+3] = r, g, b, 0xff
+		}
+	}
+	return dst
+}
+
+// toPixelBuffer converts img to an *image.RGBA, or an *image.RGBA64 for
+// 16-bit sources, with its origin at (0, 0).
+func toPixelBuffer(img image.Image) (draw.Image, int) {
+	bytesPerPixel := 4
+	if is16Bit(img) {
+		bytesPerPixel = 8
+	}
+
+	bounds := img.Bounds()
+	dst, _ := newPixelBuffer(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), bytesPerPixel)
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+	return dst, bytesPerPixel
+}
+
+func newPixelBuffer(r image.Rectangle, bytesPerPixel int) (draw.Image, int) {
+	if bytesPerPixel == 8 {
+		return image.NewRGBA64(r), bytesPerPixel
+	}
+	return image.NewRGBA(r), bytesPerPixel
+}
+
+// pixelData returns the samples and stride of a buffer made by
+// newPixelBuffer. ok is false for any other kind of image, which callers
+// then handle pixel by pixel.
+func pixelData(img draw.Image) (pix []byte, stride int, ok bool) {
+	switch m := img.(type) {
+	case *image.RGBA64:
+		return m.Pix, m.Stride, true
+	case *image.RGBA:
+		return m.Pix, m.Stride, true
+	}
+	return nil, 0, false
+}
+
+// toGray converts img to grayscale, keeping 16 bits per sample for 16-bit
+// sources and 8 otherwise. Gray images have no alpha channel, so transparent
+// areas are first filled with background, or white if it is nil.
+func toGray(img image.Image, background color.Color) image.Image {
+	switch img.(type) {
+	case *image.Gray, *image.Gray16:
+		return img
+	}
+	if background == nil {
+		background = color.White
+	}
+	deep := is16Bit(img)
+	img = flattenImage(img, background)
+	bounds := img.Bounds()
+	var gray draw.Image = image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	if deep {
+		gray = image.NewGray16(gray.Bounds())
+	}
+	draw.Draw(gray, gray.Bounds(), img, bounds.Min, draw.Src)
+	return gray
+}
+
+// is16Bit reports whether img holds 16 bits per sample, as 16-bit PNGs and
+// TIFFs decode to. Steps that build new images keep that depth for them.
+func is16Bit(img image.Image) bool {
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		return true
+	}
+	return false
+}
+
+// flattenImage composites img over a solid background so it can be saved in
+// formats without an alpha channel. Opaque images are returned unchanged.
+func flattenImage(img image.Image, background color.Color) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+
+	bounds := img.Bounds()
+	var flat draw.Image = image.NewRGBA(bounds)
+	if is16Bit(img) {
+		flat = image.NewRGBA64(bounds)
+	}
+	draw.Draw(flat, bounds, image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(flat, bounds, img, bounds.Min, draw.Over)
+	return flat
+}
+
+// cropToAspect trims img to the given width/height ratio. gravity picks which
+// part is kept: "top" and "bottom" apply when trimming height, "left" and
+// "right" when trimming width, and anything else keeps the center.
+func cropToAspect(img image.Image, aspect float64, gravity string) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	cropWidth, cropHeight := width, height
+	if float64(width)/float64(height) > aspect {
+		cropWidth = int(math.Max(1, math.Round(float64(height)*aspect)))
+	} else {
+		cropHeight = int(math.Max(1, math.Round(float64(width)/aspect)))
+	}
+	if cropWidth == width && cropHeight == height {
+		return img
+	}
+
+	x := (width - cropWidth) / 2
+	y := (height - cropHeight) / 2
+	switch gravity {
+	case "top":
+		y = 0
+	case "bottom":
+		y = height - cropHeight
+	case "left":
+		x = 0
+	case "right":
+		x = width - cropWidth
+	}
+
+	rect := image.Rect(0, 0, cropWidth, cropHeight).Add(bounds.Min).Add(image.Pt(x, y))
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(rect)
+	}
+
+	cropped := image.NewRGBA(image.Rect(0, 0, cropWidth, cropHeight))
+	draw.Draw(cropped, cropped.Bounds(), img, rect.Min, draw.Src)
+	return cropped
+}
+
+// padToSize centers img on a width x height canvas filled with background,
+// or white if it is nil.
+func padToSize(img image.Image, width, height int, background color.Color) image.Image {
+	if background == nil {
+		background = color.White
+	}
+	var canvas draw.Image = image.NewRGBA(image.Rect(0, 0, width, height))
+	if is16Bit(img) {
+		canvas = image.NewRGBA64(canvas.Bounds())
+	}
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	bounds := img.Bounds()
+	offset := image.Pt((width-bounds.Dx())/2, (height-bounds.Dy())/2)
+	draw.Draw(canvas, bounds.Sub(bounds.Min).Add(offset), img, bounds.Min, draw.Over)
+	return canvas
+}
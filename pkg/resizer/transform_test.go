@@ -0,0 +1,122 @@
+package resizer
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"testing"
+
+	"github.com/nfnt/resize"
+)
+
+func TestCMYKToRGBA(t *testing.T) {
+	tests := []struct {
+		cmyk color.CMYK
+		want color.RGBA
+	}{
+		{color.CMYK{}, color.RGBA{255, 255, 255, 255}},
+		{color.CMYK{K: 255}, color.RGBA{0, 0, 0, 255}},
+		{color.CMYK{C: 255}, color.RGBA{0, 255, 255, 255}},
+		{color.CMYK{M: 255, Y: 255}, color.RGBA{255, 0, 0, 255}},
+		{color.CMYK{C: 0, M: 128, Y: 191, K: 55}, color.RGBA{200, 100, 50, 255}},
+	}
+
+	// A sub-image checks that the origin and stride of the source are
+	// honored and the result starts at (0, 0).
+	full := image.NewCMYK(image.Rect(0, 0, len(tests)+2, 3))
+	src := full.SubImage(image.Rect(1, 1, len(tests)+1, 2)).(*image.CMYK)
+	for i, tt := range tests {
+		src.SetCMYK(1+i, 1, tt.cmyk)
+	}
+
+	dst := cmykToRGBA(src)
+	if dst.Bounds() != image.Rect(0, 0, len(tests), 1) {
+		t.Fatalf("bounds %v, want %v", dst.Bounds(), image.Rect(0, 0, len(tests), 1))
+	}
+	for i, tt := range tests {
+		if got := dst.RGBAAt(i, 0); !closeRGBA(got, tt.want, 1) {
+			t.Errorf("%v: got %v, want %v", tt.cmyk, got, tt.want)
+		}
+	}
+}
+
+func TestCMYKToRGBAEmpty(t *testing.T) {
+	dst := cmykToRGBA(image.NewCMYK(image.Rect(0, 0, 0, 0)))
+	if !dst.Bounds().Empty() {
+		t.Errorf("bounds %v, want empty", dst.Bounds())
+	}
+}
+
+// TestResizeCMYKJPEG resizes CMYK and YCCK JPEGs written by libjpeg, holding a
+// solid RGB(200, 100, 50), and checks that the output keeps that color.
+func TestResizeCMYKJPEG(t *testing.T) {
+	for _, name := range []string{"testdata/cmyk.jpg", "testdata/ycck.jpg"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		opts := &Options{MaxWidth: 8, Format: "png", Algorithm: resize.Bilinear}
+		result, err := Resize(context.Background(), bytes.NewReader(data), &out, 0, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if result.NewW != 8 || result.NewH != 8 {
+			t.Errorf("%s: resized to %dx%d, want 8x8", name, result.NewW, result.NewH)
+		}
+
+		img, err := png.Decode(&out)
+		if err != nil {
+			t.Fatalf("%s: decoding the output: %v", name, err)
+		}
+		if _, ok := img.(*image.RGBA64); ok {
+			t.Errorf("%s: output is 16-bit; CMYK should be converted to 8-bit RGB", name)
+		}
+		got := color.RGBAModel.Convert(img.At(4, 4)).(color.RGBA)
+		if want := (color.RGBA{200, 100, 50, 255}); !closeRGBA(got, want, 4) {
+			t.Errorf("%s: center pixel %v, want about %v", name, got, want)
+		}
+	}
+}
+
+// closeRGBA reports whether each channel of a and b differs by at most
+// tolerance, allowing for rounding and compression.
+func closeRGBA(a, b color.RGBA, tolerance int) bool {
+	near := func(x, y uint8) bool {
+		d := int(x) - int(y)
+		return d >= -tolerance && d <= tolerance
+	}
+	return near(a.R, b.R) && near(a.G, b.G) && near(a.B, b.B) && near(a.A, b.A)
+}
+
+func TestOrientImageAnyImageType(t *testing.T) {
+	rect := image.Rect(0, 0, 3, 2)
+	images := map[string]draw.Image{
+		"RGBA":     image.NewRGBA(rect),
+		"NRGBA":    image.NewNRGBA(rect),
+		"RGBA64":   image.NewRGBA64(rect),
+		"NRGBA64":  image.NewNRGBA64(rect),
+		"Gray":     image.NewGray(rect),
+		"Gray16":   image.NewGray16(rect),
+		"CMYK":     image.NewCMYK(rect),
+		"Paletted": image.NewPaletted(rect, color.Palette{color.Black, color.White}),
+	}
+	for name, img := range images {
+		// Mark the top-left corner; rotating 90 degrees clockwise moves it
+		// to the top-right.
+		img.Set(0, 0, color.White)
+		for orientation := 1; orientation <= 8; orientation++ {
+			out := orientImage(img, orientation)
+			if orientation >= 5 && out.Bounds().Dx() != 2 {
+				t.Errorf("%s, orientation %d: bounds %v, want 2x3", name, orientation, out.Bounds())
+			}
+		}
+		if r, _, _, _ := orientImage(img, 6).At(1, 0).RGBA(); r != 0xffff {
+			t.Errorf("%s: the corner did not move to the top-right", name)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package resizer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// decodeError wraps an error from a decoder. Errors caused by data that ends
+// early, or by a file missing the end marker of its format, are marked with
+// ErrTruncated, since decoders often report those as corrupt data instead.
+func decodeError(err error, src io.ReadSeeker, format string, size int64) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) || looksTruncated(src, format, size) {
+		return fmt.Errorf("failed to decode image: %w (%w)", ErrTruncated, err)
+	}
+	return fmt.Errorf("failed to decode image: %w", err)
+}
+
+// looksTruncated reports whether src, of the given size, lacks the trailer
+// that every complete file in format ends with.
+func looksTruncated(src io.ReadSeeker, format string, size int64) bool {
+	switch format {
+	case "jpeg", "png", "gif", "webp":
+	default:
+		return false
+	}
+
+	if format == "webp" {
+		// The RIFF header records the size of the rest of the file.
+		var header [8]byte
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return false
+		}
+		if _, err := io.ReadFull(src, header[:]); err != nil {
+			return true
+		}
+		return int64(binary.LittleEndian.Uint32(header[4:]))+8 > size
+	}
+
+	tail := make([]byte, min(size, 12))
+	if _, err := src.Seek(-int64(len(tail)), io.SeekEnd); err != nil {
+		return false
+	}
+	if _, err := io.ReadFull(src, tail); err != nil {
+		return false
+	}
+	switch format {
+	case "jpeg":
+		// Some encoders pad the file after the EOI marker.
+		return !bytes.Contains(tail, []byte{0xFF, 0xD9})
+	case "png":
+		return !bytes.Contains(tail, []byte("IEND"))
+	default: // gif
+		return tail[len(tail)-1] != 0x3B
+	}
+}
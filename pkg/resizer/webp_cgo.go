@@ -0,0 +1,17 @@
+//go:build cgo
+
+package resizer
+
+import (
+	"image"
+	"io"
+
+	"github.com/chai2010/webp"
+)
+
+// WebPEncoding reports whether this build can write WebP.
+const WebPEncoding = true
+
+func encodeWebP(w io.Writer, img image.Image, quality int, lossless bool) error {
+	return webp.Encode(w, img, &webp.Options{Lossless: lossless, Quality: float32(quality)})
+}
@@ -0,0 +1,18 @@
+//go:build !cgo
+
+package resizer
+
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+// WebPEncoding reports whether this build can write WebP. The encoder wraps
+// libwebp, so it is only available in cgo builds; decoding is pure Go and
+// works everywhere.
+const WebPEncoding = false
+
+func encodeWebP(w io.Writer, img image.Image, quality int, lossless bool) error {
+	return errors.New("WebP encoding requires a build with cgo enabled")
+}
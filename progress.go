@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/mattn/go-isatty"
+)
+
+// progressTemplate shows the files done, the throughput, and the estimated
+// time remaining.
+const progressTemplate pb.ProgressBarTemplate = `{{counters . }} {{bar . }} {{percent . }} {{string . "throughput"}} {{rtime . "ETA %s"}}`
+
+// progressInterval is how often a plain-text progress line is printed when
+// no progress bar is drawn.
+const progressInterval = 10 * time.Second
+
+// noProgressBar turns the progress bar off even on a terminal.
+var noProgressBar bool
+
+// The progress bar and messages printed during a batch share the terminal.
+// terminalMu serializes their writes, so a message is never printed in the
+// middle of a redraw, and activeBar is the bar being drawn, if any.
+var (
+	terminalMu sync.Mutex
+	activeBar  *pb.ProgressBar
+)
+
+// terminalWriter is the progress bar's output. It takes terminalMu so the
+// bar's own refreshes cannot interleave with messages.
+type terminalWriter struct {
+	out io.Writer
+}
+
+func (w terminalWriter) Write(p []byte) (int, error) {
+	terminalMu.Lock()
+	defer terminalMu.Unlock()
+	return w.out.Write(p)
+}
+
+// printAboveProgress writes text, which ends in a newline, to w. While a
+// progress bar is drawn the bar's line is cleared first and the bar is drawn
+// again below the text, so the two never share a line. On a terminal without
+// a bar the current line is still cleared, in case it holds a partial line.
+func printAboveProgress(w io.Writer, text string) {
+	terminalMu.Lock()
+	defer terminalMu.Unlock()
+
+	if activeBar != nil {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		fmt.Fprint(w, text)
+		fmt.Fprint(os.Stderr, activeBar.String())
+		return
+	}
+	if f, ok := w.(*os.File); ok && isatty.IsTerminal(f.Fd()) {
+		fmt.Fprint(w, "\r\033[K")
+	}
+	fmt.Fprint(w, text)
+}
+
+// batchProgress reports how far a batch has got. It draws a progress bar when
+// stderr is a terminal, and otherwise prints a plain line now and then so logs
+// and pipes are not filled with control characters.
+type batchProgress struct {
+	bar   *pb.ProgressBar
+	total int
+	start time.Time
+
+	done      atomic.Int64
+	bytesRead atomic.Int64
+
+	mu         sync.Mutex
+	lastReport time.Time
+}
+
+func newBatchProgress(total int) *batchProgress {
+	p := &batchProgress{total: total, start: time.Now()}
+	p.lastReport = p.start
+	if !noProgressBar && isatty.IsTerminal(os.Stderr.Fd()) {
+		p.bar = progressTemplate.New(total).SetWriter(terminalWriter{os.Stderr})
+		p.bar.Set(pb.Terminal, true)
+		p.bar.Start()
+		terminalMu.Lock()
+		activeBar = p.bar
+		terminalMu.Unlock()
+	}
+	return p
+}
+
+// fileDone records a finished file and the size of its source. Throughput
+// counts source bytes, which tracks the decoding work far better than the
+// file count on mixed batches.
+func (p *batchProgress) fileDone(sourceBytes int64) {
+	done := p.done.Add(1)
+	rate := formatBytes(int64(float64(p.bytesRead.Add(sourceBytes))/time.Since(p.start).Seconds())) + "/s"
+
+	if p.bar != nil {
+		p.bar.Set("throughput", rate)
+		p.bar.Increment()
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Since(p.lastReport) < progressInterval {
+		return
+	}
+	p.lastReport = time.Now()
+	logNow(fmt.Sprintf("Progress: %d of %d files (%s)", done, p.total, rate))
+}
+
+func (p *batchProgress) finish() {
+	if p.bar != nil {
+		terminalMu.Lock()
+		activeBar = nil
+		terminalMu.Unlock()
+		p.bar.Finish()
+	}
+}
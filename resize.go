@@ -0,0 +1,822 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"restore/pkg/resizer"
+)
+
+// resizeCommand is the default command: resize images to fit within the
+// memory and size limits, optionally converting them as well.
+func resizeCommand() *cli.Command {
+	flags := withConfigFile(append(sharedFlags(), resizeFlags()...))
+	return &cli.Command{
+		Name:      "resize",
+		Usage:     "Resize images to fit within a memory limit (the default command)",
+		ArgsUsage: "<file, directory or pattern>...",
+		Flags:     flags,
+		Before: func(c *cli.Context) error {
+			return setUpCommand(c, flags)
+		},
+		Action: runResize,
+	}
+}
+
+// convertCommand saves images in another format. It shares the resize
+// pipeline and flags, but --format is required and images are only made
+// smaller when a limit is given explicitly.
+func convertCommand() *cli.Command {
+	flags := resizeFlags()
+	for _, flag := range flags {
+		if f, ok := flag.(*cli.StringFlag); ok && f.Name == "format" {
+			f.Required = true
+		}
+	}
+	flags = withConfigFile(append(sharedFlags(), flags...))
+	return &cli.Command{
+		Name:      "convert",
+		Usage:     "Convert images to another format, resizing only when a limit is given",
+		ArgsUsage: "<file, directory or pattern>...",
+		Flags:     flags,
+		Before: func(c *cli.Context) error {
+			return setUpCommand(c, flags)
+		},
+		Action: runResize,
+	}
+}
+
+// setUpCommand applies the shared flags given before the command name and the
+// config file to flags, then sets up logging from the result.
+func setUpCommand(c *cli.Context, flags []cli.Flag) error {
+	if err := inheritSharedFlags(c); err != nil {
+		return err
+	}
+	if err := applyConfigFile(c, flags); err != nil {
+		return err
+	}
+	return setUpLogging(c)
+}
+
+// resizeFlags returns the flags of the resize and convert commands. Each
+// call returns new flags, as urfave/cli keeps parsed values in them.
+func resizeFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.Int64Flag{
+			Name:    "memory",
+			Aliases: []string{"m"},
+			Usage:   "Maximum memory limit in bytes (default: 2GB)",
+			Value:   2 * 1024 * 1024 * 1024, // Default to 2GB
+		},
+		&cli.IntFlag{
+			Name:  "max-width",
+			Usage: "Maximum output width in pixels (preserves aspect ratio)",
+		},
+		&cli.IntFlag{
+			Name:  "max-height",
+			Usage: "Maximum output height in pixels (preserves aspect ratio)",
+		},
+		&cli.IntFlag{
+			Name:  "min-width",
+			Usage: "Skip images narrower than this many pixels",
+		},
+		&cli.IntFlag{
+			Name:  "min-height",
+			Usage: "Skip images shorter than this many pixels",
+		},
+		&cli.Int64Flag{
+			Name:  "min-bytes",
+			Usage: "Skip files smaller than this many bytes",
+		},
+		&cli.StringFlag{
+			Name:  "scale",
+			Usage: "Resize by a scale factor such as 0.5 or 50% instead of a memory limit",
+		},
+		&cli.BoolFlag{
+			Name:  "allow-upscale",
+			Usage: "Allow images smaller than the target size to be enlarged",
+		},
+		&cli.StringFlag{
+			Name:  "fit",
+			Usage: "Produce exactly --max-width x --max-height: contain (pad with --background), cover (crop to fill), or stretch; cover and stretch only enlarge smaller images with --allow-upscale",
+		},
+		&cli.StringFlag{
+			Name:  "crop-to-aspect",
+			Usage: "Crop images to an aspect ratio such as 1:1 or 16:9 before resizing",
+		},
+		&cli.StringFlag{
+			Name:  "crop-gravity",
+			Usage: "Which part of the image to keep when cropping (center, top, bottom, left, right)",
+			Value: "center",
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "Directory to save resized images (default: current working directory)",
+			Value:   ".", // Default to the current working directory
+		},
+		&cli.StringFlag{
+			Name:  "suffix",
+			Usage: "Text added to output file names before the extension; shorthand for --name-template \"{name}<suffix>{ext}\"",
+			Value: "-resized",
+		},
+		&cli.StringFlag{
+			Name:  "name-template",
+			Usage: "Output file name template using {name}, {ext}, {width}, {height}, and {dpi}",
+			Value: "{name}-resized{ext}",
+		},
+		&cli.StringFlag{
+			Name:    "algorithm",
+			Aliases: []string{"a"},
+			Usage:   "Resize algorithm to use (lanczos, lanczos2, bicubic, mitchell, bilinear, nearest)",
+			Value:   "lanczos",
+		},
+		&cli.StringFlag{
+			Name:    "quality",
+			Aliases: []string{"q"},
+			Usage:   "JPEG, lossy WebP and AVIF quality (1-100), optionally per format such as jpeg=85,webp=80",
+			Value:   "75",
+		},
+		&cli.StringFlag{
+			Name:  "target-size",
+			Usage: "Lower the quality of JPEG, lossy WebP and AVIF outputs as needed to keep each file under this size, such as 200KB",
+		},
+		&cli.BoolFlag{
+			Name:  "output-quality-auto",
+			Usage: "Use the lowest JPEG or lossy WebP quality, up to --quality, whose output keeps an SSIM of at least --min-ssim",
+		},
+		&cli.Float64Flag{
+			Name:  "min-ssim",
+			Usage: "Structural similarity (0-1) --output-quality-auto keeps outputs at; setting it implies --output-quality-auto",
+			Value: resizer.DefaultMinSSIM,
+		},
+		&cli.BoolFlag{
+			Name:  "progressive",
+			Usage: "Write progressive JPEGs, which load in passes of increasing detail (requires a build with the libjpeg tag)",
+		},
+		&cli.StringFlag{
+			Name:  "subsampling",
+			Usage: "JPEG chroma subsampling: 444 keeps colored edges and text sharp, 422 and 420 are smaller (444 and 422 require a build with the libjpeg tag)",
+			Value: "420",
+		},
+		&cli.BoolFlag{
+			Name:  "lossless",
+			Usage: "Use lossless compression for WebP output",
+		},
+		&cli.BoolFlag{
+			Name:  "in-place",
+			Usage: "Overwrite the source files with their resized versions",
+		},
+		&cli.BoolFlag{
+			Name:  "keep-larger",
+			Usage: "Write resized files even when they are not smaller than the source",
+		},
+		&cli.BoolFlag{
+			Name:  "skip-optimized",
+			Usage: "Leave JPEGs that already look heavily compressed (under 1 bit per pixel) as they are",
+		},
+		&cli.BoolFlag{
+			Name:  "skip-if-larger",
+			Usage: "Also discard format conversions, rotations, grayscale conversions and overlays that are not smaller than the source",
+		},
+		&cli.BoolFlag{
+			Name:  "overwrite",
+			Usage: "Replace output files that already exist",
+		},
+		&cli.BoolFlag{
+			Name:  "skip-existing",
+			Usage: "Skip files whose output already exists (default behavior)",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Simulate resizing without saving files",
+		},
+		&cli.BoolFlag{
+			Name:  "no-progress",
+			Usage: "Never draw a progress bar; print a progress line every 10 seconds instead",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Print one JSON object per file and a final summary object to stdout",
+		},
+		&cli.IntFlag{
+			Name:  "max-files",
+			Usage: "Only process the first N files found across all inputs, to try settings on a sample",
+		},
+		&cli.IntFlag{
+			Name:  "sample",
+			Usage: "Process N files chosen at random from all inputs, for a representative preview",
+		},
+		&cli.Int64Flag{
+			Name:  "seed",
+			Usage: "Random seed for --sample, so the same files are picked again (default: a new seed each run, which is logged)",
+		},
+		&cli.StringFlag{
+			Name:  "state-file",
+			Usage: "Record finished source files in this file and skip the files it lists, so an interrupted batch can be resumed",
+		},
+		&cli.StringFlag{
+			Name:  "manifest",
+			Usage: "Append one CSV row per file to this file, recording paths, sizes, DPI, algorithm and status",
+		},
+		&cli.BoolFlag{
+			Name:  "fail-on-error",
+			Usage: "Exit with status 2 if any file failed to process, instead of reporting partial success as success",
+		},
+		&cli.BoolFlag{
+			Name:    "recursive",
+			Aliases: []string{"r"},
+			Usage:   "Process directories recursively",
+		},
+		&cli.BoolFlag{
+			Name:  "dedupe",
+			Usage: "Skip files whose contents are identical to a file already processed in this run",
+		},
+		&cli.BoolFlag{
+			Name:  "flatten",
+			Usage: "Write outputs from subfolders directly into the output directory, naming them after their folders to keep names unique",
+		},
+		&cli.StringFlag{
+			Name:  "zip",
+			Usage: "Store all outputs in this ZIP archive instead of writing separate files",
+		},
+		&cli.BoolFlag{
+			Name:  "follow-symlinks",
+			Usage: "Descend into symlinked directories when processing recursively",
+		},
+		&cli.StringFlag{
+			Name:    "format",
+			Aliases: []string{"f"},
+			Usage:   "Convert images to this format (png, jpeg, webp, avif, gif, tiff, bmp) instead of keeping the source format",
+		},
+		&cli.BoolFlag{
+			Name:  "lowercase-ext",
+			Usage: "Write output extensions in lowercase, so IMG_1234.JPG becomes IMG_1234-resized.jpg",
+		},
+		&cli.BoolFlag{
+			Name:  "canonical-ext",
+			Usage: "Use one extension per format (.jpg, .tiff) in lowercase, so .jpeg and .tif outputs become .jpg and .tiff",
+		},
+		&cli.StringFlag{
+			Name:  "input-format",
+			Usage: "Treat files without an extension as this format (jpeg, png, webp, gif, tiff, bmp, heic) so they are processed and named accordingly",
+		},
+		&cli.StringFlag{
+			Name:  "background",
+			Usage: "Hex color used behind transparent areas when saving to formats without alpha",
+			Value: "#ffffff",
+		},
+		&cli.StringFlag{
+			Name:  "watermark",
+			Usage: "Stamp this image, such as a PNG logo, onto every resized image",
+		},
+		&cli.StringFlag{
+			Name:  "watermark-position",
+			Usage: "Where to place the watermark (top-left, top-right, bottom-left, bottom-right, center)",
+			Value: "bottom-right",
+		},
+		&cli.Float64Flag{
+			Name:  "watermark-opacity",
+			Usage: "Opacity of the watermark from 0 (invisible) to 1 (opaque)",
+			Value: 0.5,
+		},
+		&cli.StringFlag{
+			Name:  "text",
+			Usage: "Write this text, such as a copyright notice, onto every resized image; {name} is replaced by the file name",
+		},
+		&cli.StringFlag{
+			Name:  "text-position",
+			Usage: "Where to place the text (top-left, top-right, bottom-left, bottom-right, center)",
+			Value: "bottom-left",
+		},
+		&cli.StringFlag{
+			Name:  "text-color",
+			Usage: "Hex color of the text",
+			Value: "#ffffff",
+		},
+		&cli.StringFlag{
+			Name:  "text-background",
+			Usage: "Hex color of a box drawn behind the text, such as #00000080 for translucent black (default: none)",
+		},
+		&cli.StringFlag{
+			Name:  "png-compression",
+			Usage: "PNG compression level to use (default, none, speed, best)",
+			Value: "default",
+		},
+		&cli.StringFlag{
+			Name:  "tiff-compression",
+			Usage: "TIFF compression to use (deflate, none)",
+			Value: "deflate",
+		},
+		&cli.BoolFlag{
+			Name:  "gif-all-frames",
+			Usage: "Resize every frame of animated GIFs instead of only the first",
+		},
+		&cli.BoolFlag{
+			Name:  "dither",
+			Usage: "Apply Floyd-Steinberg dithering when reducing colors for GIF output",
+		},
+		&cli.BoolFlag{
+			Name:  "preserve-exif",
+			Usage: "Copy EXIF metadata from JPEG sources into the resized JPEG",
+		},
+		&cli.BoolFlag{
+			Name:  "regenerate-thumbnail",
+			Usage: "Replace the thumbnail in preserved EXIF data with one made from the resized image",
+		},
+		&cli.BoolFlag{
+			Name:  "preserve-mtime",
+			Usage: "Give each output the modification time of its source file",
+		},
+		&cli.BoolFlag{
+			Name:  "strip-metadata",
+			Usage: "Guarantee that no EXIF metadata (GPS, serial numbers, timestamps) is written; overrides --preserve-exif",
+		},
+		&cli.BoolFlag{
+			Name:  "preserve-icc",
+			Usage: "Embed the source's ICC color profile in JPEG and PNG output",
+			Value: true,
+		},
+		&cli.BoolFlag{
+			Name:  "auto-orient",
+			Usage: "Rotate images upright according to their EXIF orientation",
+			Value: true,
+		},
+		&cli.IntFlag{
+			Name:  "rotate",
+			Usage: "Rotate images clockwise by 90, 180 or 270 degrees, after any EXIF orientation",
+		},
+		&cli.StringFlag{
+			Name:  "flip",
+			Usage: "Mirror images horizontally or vertically, after any rotation (horizontal, vertical)",
+		},
+		&cli.BoolFlag{
+			Name:  "grayscale",
+			Usage: "Convert images to grayscale before resizing, which shrinks scans of documents",
+		},
+		&cli.IntFlag{
+			Name:    "concurrency",
+			Aliases: []string{"j", "threads"},
+			Usage:   "Maximum number of images to process at the same time",
+			Value:   runtime.NumCPU(),
+		},
+		&cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "Give up on a file that takes longer than this to process, such as 30s or 2m (0 means no limit)",
+		},
+		&cli.DurationFlag{
+			Name:  "url-timeout",
+			Usage: "Give up on downloading an http or https input after this long (0 means no limit)",
+			Value: defaultURLTimeout,
+		},
+		&cli.StringFlag{
+			Name:  "max-download-size",
+			Usage: "Refuse http and https inputs larger than this, such as 50MB, as each is held in memory (0 means no limit)",
+			Value: defaultMaxDownloadSize,
+		},
+		&cli.Int64Flag{
+			Name:  "max-pixels",
+			Usage: "Refuse to decode images whose header declares more pixels than this, to guard against decompression bombs (0 means no limit)",
+			Value: 500_000_000,
+		},
+		&cli.Int64Flag{
+			Name:  "total-memory",
+			Usage: "Maximum bytes of decoded image data held by all workers at once (default: unlimited)",
+		},
+		&cli.IntFlag{
+			Name:    "dpi",
+			Aliases: []string{"d"},
+			Usage:   "Set the DPI for the output image. If not set, it will be read from the image (EXIF, or the pHYs chunk of PNGs) if available",
+			Value:   0, // Default DPI is unset
+		},
+		&cli.IntFlag{
+			Name:  "target-dpi",
+			Usage: "Resample images so they print at their original physical size at this DPI",
+		},
+		&cli.BoolFlag{
+			Name:  "snap-to-dpi",
+			Usage: "Round widths chosen by --memory down to a whole number of inches at the source DPI",
+		},
+		&cli.StringSliceFlag{
+			Name:  "exclude",
+			Usage: "Skip files and folders matching this glob while scanning directories (repeatable), e.g. '*-resized.*' or 'thumbnails/'",
+		},
+		&cli.BoolFlag{
+			Name:  "no-ignore-file",
+			Usage: "Scan directories without applying their .resizerignore files",
+		},
+		&cli.StringFlag{
+			Name:  "files-from",
+			Usage: "Read newline-separated input file paths from this file, or from stdin when set to -",
+		},
+	}
+}
+
+// runResize processes the images named on the command line for the resize
+// and convert commands.
+func runResize(c *cli.Context) error {
+	noProgressBar = c.Bool("no-progress")
+
+	opts := &options{
+		Options: resizer.Options{
+			MemoryLimit:         c.Int64("memory"),
+			MaxPixels:           c.Int64("max-pixels"),
+			MaxWidth:            c.Int("max-width"),
+			MaxHeight:           c.Int("max-height"),
+			AllowUpscale:        c.Bool("allow-upscale"),
+			TargetDPI:           c.Int("target-dpi"),
+			SnapToDPI:           c.Bool("snap-to-dpi"),
+			Fit:                 strings.ToLower(c.String("fit")),
+			CropGravity:         strings.ToLower(c.String("crop-gravity")),
+			Progressive:         c.Bool("progressive"),
+			Subsampling:         c.String("subsampling"),
+			Lossless:            c.Bool("lossless"),
+			GIFAllFrames:        c.Bool("gif-all-frames"),
+			Dither:              c.Bool("dither"),
+			PreserveEXIF:        c.Bool("preserve-exif"),
+			RegenerateThumbnail: c.Bool("regenerate-thumbnail"),
+			PreserveICC:         c.Bool("preserve-icc"),
+			AutoOrient:          c.Bool("auto-orient"),
+			Rotate:              c.Int("rotate"),
+			Flip:                strings.ToLower(c.String("flip")),
+			Grayscale:           c.Bool("grayscale"),
+			PreserveModTime:     c.Bool("preserve-mtime"),
+			KeepLarger:          c.Bool("keep-larger"),
+			SkipIfLarger:        c.Bool("skip-if-larger"),
+			SkipOptimized:       c.Bool("skip-optimized"),
+			DryRun:              c.Bool("dry-run"),
+			Logger:              cliLogger{},
+		},
+		outputDir:      c.String("output"),
+		recursive:      c.Bool("recursive"),
+		flatten:        c.Bool("flatten"),
+		followSymlinks: c.Bool("follow-symlinks"),
+		timeout:        c.Duration("timeout"),
+		urlTimeout:     c.Duration("url-timeout"),
+		dpi:            c.Int("dpi"),
+		minWidth:       c.Int("min-width"),
+		minHeight:      c.Int("min-height"),
+		minBytes:       c.Int64("min-bytes"),
+		exclude:        c.StringSlice("exclude"),
+		noIgnoreFile:   c.Bool("no-ignore-file"),
+		nameTemplate:   c.String("name-template"),
+		overwrite:      c.Bool("overwrite"),
+		inPlace:        c.Bool("in-place"),
+		concurrency:    c.Int("concurrency"),
+		qualitySet:     c.IsSet("quality"),
+		json:           c.Bool("json"),
+		maxFiles:       c.Int("max-files"),
+		sample:         c.Int("sample"),
+		seed:           c.Int64("seed"),
+		lowercaseExt:   c.Bool("lowercase-ext"),
+		canonicalExt:   c.Bool("canonical-ext"),
+	}
+
+	if c.Bool("dedupe") {
+		opts.duplicates = newDuplicateIndex()
+	}
+
+	if opts.json {
+		messageOutput = os.Stderr
+	}
+
+	// Stripping wins so privacy never depends on flag order.
+	if c.Bool("strip-metadata") {
+		if opts.PreserveEXIF {
+			logWarn("--strip-metadata overrides --preserve-exif; no EXIF data will be written")
+		}
+		opts.PreserveEXIF = false
+	}
+	if opts.RegenerateThumbnail && !opts.PreserveEXIF {
+		logWarn("--regenerate-thumbnail has no effect without --preserve-exif")
+	}
+
+	background, err := parseHexColor(c.String("background"))
+	if err != nil {
+		return err
+	}
+	opts.Background = background
+
+	switch opts.Fit {
+	case "":
+	case "contain", "cover", "stretch":
+		if opts.MaxWidth <= 0 || opts.MaxHeight <= 0 {
+			return fmt.Errorf("--fit requires both --max-width and --max-height")
+		}
+	default:
+		return fmt.Errorf("invalid fit mode %q (valid modes: contain, cover, stretch)", opts.Fit)
+	}
+
+	if c.IsSet("crop-to-aspect") {
+		aspect, err := parseAspectRatio(c.String("crop-to-aspect"))
+		if err != nil {
+			return err
+		}
+		opts.CropAspect = aspect
+	}
+	switch opts.CropGravity {
+	case "center", "top", "bottom", "left", "right":
+	default:
+		return fmt.Errorf("invalid crop gravity %q (valid values: center, top, bottom, left, right)", opts.CropGravity)
+	}
+
+	if path := c.String("watermark"); path != "" {
+		watermark, err := loadWatermark(path, strings.ToLower(c.String("watermark-position")), c.Float64("watermark-opacity"))
+		if err != nil {
+			return err
+		}
+		opts.Watermark = watermark
+	}
+
+	if text := c.String("text"); text != "" {
+		caption, err := parseCaption(text, strings.ToLower(c.String("text-position")), c.String("text-color"), c.String("text-background"))
+		if err != nil {
+			return err
+		}
+		opts.Caption = caption
+	}
+
+	switch opts.Rotate {
+	case 0, 90, 180, 270:
+	default:
+		return fmt.Errorf("invalid rotation %d (valid values: 90, 180, 270)", opts.Rotate)
+	}
+	switch opts.Flip {
+	case "", "horizontal", "vertical":
+	default:
+		return fmt.Errorf("invalid flip %q (valid values: horizontal, vertical)", opts.Flip)
+	}
+
+	if c.IsSet("suffix") {
+		if c.IsSet("name-template") {
+			return fmt.Errorf("--suffix and --name-template cannot be used together")
+		}
+		opts.nameTemplate = "{name}" + c.String("suffix") + "{ext}"
+	}
+	// Checked once every input is known, including those from --files-from.
+	emptySuffix := c.IsSet("suffix") && c.String("suffix") == "" && !opts.inPlace
+
+	if !opts.inPlace {
+		opts.outputPattern = outputNamePattern(opts.nameTemplate)
+	}
+
+	if c.IsSet("input-format") {
+		inputFormat, err := normalizeInputFormat(c.String("input-format"))
+		if err != nil {
+			return err
+		}
+		opts.inputFormat = inputFormat
+	}
+
+	if c.IsSet("format") {
+		format, err := resizer.NormalizeFormat(c.String("format"))
+		if err != nil {
+			return err
+		}
+		if opts.inPlace {
+			return fmt.Errorf("--in-place cannot be combined with --format")
+		}
+		opts.Format = format
+	}
+
+	algorithm, err := resizer.GetResizeAlgorithm(c.String("algorithm"))
+	if err != nil {
+		return err
+	}
+	opts.Algorithm = algorithm
+
+	pngCompression, err := getPNGCompression(c.String("png-compression"))
+	if err != nil {
+		return err
+	}
+	opts.PNGCompression = pngCompression
+
+	tiffCompression, err := getTIFFCompression(c.String("tiff-compression"))
+	if err != nil {
+		return err
+	}
+	opts.TIFFCompression = tiffCompression
+
+	opts.Quality, opts.FormatQuality, err = parseQuality(c.String("quality"))
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(resizer.ChromaSubsamplings, opts.Subsampling) {
+		return fmt.Errorf("invalid subsampling %q (valid values: %s)", opts.Subsampling, strings.Join(resizer.ChromaSubsamplings, ", "))
+	}
+
+	if opts.Format == "webp" && !resizer.WebPEncoding {
+		return fmt.Errorf("--format webp is not supported by this build; rebuild with cgo enabled")
+	}
+	if opts.Format == "avif" && !resizer.AVIFEncoding {
+		return fmt.Errorf("--format avif is not supported by this build; rebuild with cgo enabled and -tags avif")
+	}
+	if !resizer.LibJPEG {
+		if opts.Progressive {
+			return fmt.Errorf("--progressive is not supported by this build; rebuild with cgo enabled and -tags libjpeg")
+		}
+		if opts.Subsampling != "420" {
+			return fmt.Errorf("--subsampling %s is not supported by this build; rebuild with cgo enabled and -tags libjpeg", opts.Subsampling)
+		}
+	}
+
+	if opts.TargetDPI < 0 {
+		return fmt.Errorf("--target-dpi must be positive")
+	}
+
+	maxDownloadSize, err := parseByteLimit(c.String("max-download-size"))
+	if err != nil {
+		return err
+	}
+	opts.maxDownloadSize = maxDownloadSize
+
+	if c.IsSet("target-size") {
+		targetSize, err := parseByteSize(c.String("target-size"))
+		if err != nil {
+			return err
+		}
+		opts.TargetSize = targetSize
+	}
+
+	if c.Bool("output-quality-auto") || c.IsSet("min-ssim") {
+		if opts.TargetSize > 0 {
+			return fmt.Errorf("--output-quality-auto and --target-size cannot be used together")
+		}
+		opts.MinSSIM = c.Float64("min-ssim")
+		if opts.MinSSIM <= 0 || opts.MinSSIM >= 1 {
+			return fmt.Errorf("--min-ssim must be between 0 and 1, such as 0.98")
+		}
+	}
+
+	if opts.maxFiles < 0 {
+		return fmt.Errorf("--max-files must be positive")
+	}
+	if opts.sample < 0 {
+		return fmt.Errorf("--sample must be positive")
+	}
+	if opts.sample > 0 && opts.maxFiles > 0 {
+		return fmt.Errorf("--sample and --max-files cannot be used together")
+	}
+	if opts.sample > 0 && !c.IsSet("seed") {
+		opts.seed = time.Now().UnixNano()
+	}
+
+	if opts.concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1")
+	}
+
+	if totalMemory := c.Int64("total-memory"); totalMemory > 0 {
+		opts.Budget = resizer.NewMemoryBudget(totalMemory)
+	}
+
+	if opts.inPlace && opts.flatten {
+		return fmt.Errorf("--in-place and --flatten cannot be used together")
+	}
+
+	if opts.inPlace && c.IsSet("output") {
+		return fmt.Errorf("--in-place and --output cannot be used together")
+	}
+
+	if opts.KeepLarger && opts.SkipIfLarger {
+		return fmt.Errorf("--keep-larger and --skip-if-larger cannot be used together")
+	}
+
+	if opts.overwrite && c.Bool("skip-existing") {
+		return fmt.Errorf("--overwrite and --skip-existing cannot be used together")
+	}
+
+	if c.IsSet("scale") {
+		if c.IsSet("memory") {
+			return fmt.Errorf("--scale and --memory cannot be used together")
+		}
+		scale, err := parseScale(c.String("scale"))
+		if err != nil {
+			return err
+		}
+		if scale > 1 && !opts.AllowUpscale {
+			return fmt.Errorf("scale %v would enlarge images; pass --allow-upscale to permit this", scale)
+		}
+		opts.Scale = scale
+		opts.MemoryLimit = 0
+	}
+
+	// Pixel caps replace the default memory limit unless one was given
+	// explicitly, and convert only applies limits that were asked for.
+	if (opts.MaxWidth > 0 || opts.MaxHeight > 0 || c.Command.Name == "convert") && !c.IsSet("memory") {
+		opts.MemoryLimit = 0
+	}
+
+	filesFrom := c.String("files-from")
+	if c.NArg() == 0 && filesFrom == "" {
+		return fmt.Errorf("no input files or directories provided")
+	}
+
+	if zipPath := c.String("zip"); zipPath != "" {
+		if opts.inPlace || c.IsSet("output") {
+			return fmt.Errorf("--zip cannot be combined with --in-place or --output")
+		}
+		if !opts.DryRun {
+			archive, err := createZipArchive(zipPath)
+			if err != nil {
+				return err
+			}
+			opts.archive = archive
+			defer archive.abort()
+		}
+	}
+
+	if !opts.DryRun && !opts.inPlace && opts.archive == nil {
+		if err := prepareOutputDir(opts.outputDir); err != nil {
+			return err
+		}
+	}
+
+	if statePath := c.String("state-file"); statePath != "" {
+		if opts.archive != nil {
+			return fmt.Errorf("--state-file cannot be combined with --zip, as an archive cannot be resumed")
+		}
+		state, err := openStateFile(statePath)
+		if err != nil {
+			return err
+		}
+		opts.state = state
+	}
+
+	if manifestPath := c.String("manifest"); manifestPath != "" {
+		manifest, err := openManifest(manifestPath, strings.ToLower(c.String("algorithm")), opts.DryRun)
+		if err != nil {
+			return err
+		}
+		opts.manifest = manifest
+	}
+
+	ctx := c.Context
+	summary := &runSummary{}
+	// Gather every input first so one worker pool and progress bar
+	// cover the whole run.
+	var files []batchFile
+	for _, path := range c.Args().Slice() {
+		if ctx.Err() != nil {
+			break
+		}
+		files = append(files, expandPath(path, opts, summary)...)
+	}
+	if filesFrom != "" && ctx.Err() == nil {
+		list := os.Stdin
+		if filesFrom != "-" {
+			list, err = os.Open(filesFrom)
+			if err != nil {
+				return fmt.Errorf("failed to open file list: %w", err)
+			}
+			defer list.Close()
+		}
+		listed, err := readFileList(list, opts, summary)
+		if err != nil {
+			return err
+		}
+		files = append(files, listed...)
+	}
+	if emptySuffix {
+		for _, file := range files {
+			if !isURL(file.path) && samePath(file.root, opts.outputDir) {
+				return fmt.Errorf("an empty --suffix with the output directory %s would overwrite the source images; choose a different --output", file.root)
+			}
+		}
+	}
+	processBatch(ctx, files, opts, summary)
+	if opts.archive != nil {
+		if err := opts.archive.close(); err != nil {
+			return err
+		}
+	}
+	if opts.manifest != nil {
+		if err := opts.manifest.close(); err != nil {
+			return err
+		}
+	}
+	if opts.state != nil {
+		if err := opts.state.close(); err != nil {
+			return err
+		}
+	}
+	flushMessages()
+	if opts.json {
+		summary.printJSON()
+	} else {
+		summary.print()
+	}
+	if ctx.Err() != nil {
+		return cli.Exit("interrupted before all files were processed", exitInterrupted)
+	}
+	if failed := summary.failedCount(); failed > 0 && c.Bool("fail-on-error") {
+		return cli.Exit(fmt.Sprintf("%d files failed", failed), exitFilesFailed)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// stateFile lists the source files a batch has finished, one absolute path
+// per line, for --state-file. A re-run skips every file listed, whether or
+// not its output still exists.
+type stateFile struct {
+	mu     sync.Mutex
+	file   *os.File
+	done   map[string]bool
+	failed bool
+}
+
+// openStateFile reads the files completed by earlier runs from path,
+// creating it if needed, and opens it for recording more.
+func openStateFile(path string) (*stateFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state file: %w", err)
+	}
+
+	done := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			done[line] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	return &stateFile{file: file, done: done}, nil
+}
+
+// stateKey identifies a source file independently of how its path was given.
+// URLs are used as they are.
+func stateKey(path string) string {
+	if isURL(path) {
+		return path
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// completed reports whether an earlier run finished path.
+func (s *stateFile) completed(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.done[stateKey(path)]
+}
+
+// markCompleted records that path is finished. Each entry is synced to disk
+// straight away so it survives the process being killed. A write error is
+// reported once; the run carries on without recording further progress.
+func (s *stateFile) markCompleted(path string) {
+	key := stateKey(path)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done[key] || s.failed {
+		return
+	}
+	s.done[key] = true
+	_, err := s.file.WriteString(key + "\n")
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		s.failed = true
+		logError(fmt.Sprintf("Failed to update state file, progress will no longer be recorded: %v", err))
+	}
+}
+
+// close closes the state file.
+func (s *stateFile) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
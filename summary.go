@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+
+	"restore/pkg/resizer"
+)
+
+// runSummary accumulates per-file results across a whole run.
+type runSummary struct {
+	mu         sync.Mutex
+	processed  int
+	skipped    int
+	failed     int
+	bytesSaved int64
+	failures   []failure
+
+	// Empty and truncated files are also counted as failed; they are kept
+	// apart because they usually need deleting or downloading again.
+	empty     int
+	truncated int
+
+	// Outputs discarded for not being smaller than their source are counted
+	// as skipped and listed so it is clear why they are missing.
+	larger []string
+
+	// Dry runs only estimate what each output would take.
+	estimated      bool
+	estimatedSaved int64
+}
+
+// failure is a file that could not be processed.
+type failure struct {
+	path string
+	err  error
+}
+
+func (s *runSummary) record(path string, result resizer.Result, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case err != nil:
+		s.failed++
+		s.failures = append(s.failures, failure{path, err})
+		if errors.Is(err, resizer.ErrEmptyFile) {
+			s.empty++
+		} else if errors.Is(err, resizer.ErrTruncated) {
+			s.truncated++
+		}
+	case result.Skipped:
+		s.skipped++
+		if result.Larger {
+			s.larger = append(s.larger, path)
+		}
+	default:
+		s.processed++
+		if result.OutputBytes > 0 {
+			s.bytesSaved += result.SourceBytes - result.OutputBytes
+		}
+		if result.EstimatedBytes > 0 {
+			s.estimated = true
+			s.estimatedSaved += result.SourceBytes - result.EstimatedBytes
+		}
+	}
+}
+
+func (s *runSummary) print() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Println("Summary:")
+	fmt.Printf("  Processed: %d\n", s.processed)
+	fmt.Printf("  Skipped:   %d\n", s.skipped)
+	if len(s.larger) > 0 {
+		fmt.Printf("    Larger:    %d\n", len(s.larger))
+	}
+	fmt.Printf("  Failed:    %d\n", s.failed)
+	if s.empty > 0 || s.truncated > 0 {
+		fmt.Printf("    Empty:     %d\n", s.empty)
+		fmt.Printf("    Truncated: %d\n", s.truncated)
+	}
+	if s.estimated {
+		fmt.Printf("  Estimated savings: %s\n", formatBytes(s.estimatedSaved))
+	} else {
+		fmt.Printf("  Saved:     %s\n", formatBytes(s.bytesSaved))
+	}
+
+	if len(s.larger) > 0 {
+		fmt.Println("Not written because the output was not smaller than the source (use --keep-larger to write them):")
+		for _, path := range s.larger {
+			fmt.Printf("  %s\n", path)
+		}
+	}
+
+	if len(s.failures) == 0 {
+		return
+	}
+	// Group the failed files by cause, in the order each cause first appeared.
+	var reasons []string
+	groups := map[string][]string{}
+	for _, f := range s.failures {
+		reason := failureReason(f.err)
+		if _, ok := groups[reason]; !ok {
+			reasons = append(reasons, reason)
+		}
+		groups[reason] = append(groups[reason], f.path)
+	}
+	fmt.Println("Failed files:")
+	for _, reason := range reasons {
+		fmt.Printf("  %s:\n", reason)
+		for _, path := range groups[reason] {
+			fmt.Printf("    %s\n", path)
+		}
+	}
+}
+
+// failureReason describes err without the path that a file system error
+// carries, so failures with the same cause group together.
+func failureReason(err error) string {
+	for _, known := range []error{resizer.ErrEmptyFile, resizer.ErrTruncated, resizer.ErrTooManyPixels} {
+		if errors.Is(err, known) {
+			return known.Error()
+		}
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err.Error()
+	}
+	return err.Error()
+}
+
+// failedCount returns the number of files that could not be processed.
+func (s *runSummary) failedCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failed
+}
+
+type jsonDimensions struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+type jsonResult struct {
+	Type               string          `json:"type"`
+	Path               string          `json:"path"`
+	OriginalDimensions *jsonDimensions `json:"originalDimensions,omitempty"`
+	NewDimensions      *jsonDimensions `json:"newDimensions,omitempty"`
+	DPI                int             `json:"dpi,omitempty"`
+	OutputPath         string          `json:"outputPath,omitempty"`
+	EstimatedBytes     int64           `json:"estimatedBytes,omitempty"`
+	Quality            int             `json:"quality,omitempty"`
+	SSIM               float64         `json:"ssim,omitempty"`
+	Skipped            bool            `json:"skipped"`
+	Larger             bool            `json:"larger,omitempty"`
+	Error              string          `json:"error,omitempty"`
+}
+
+type jsonFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+type jsonSummary struct {
+	Type       string        `json:"type"`
+	Processed  int           `json:"processed"`
+	Skipped    int           `json:"skipped"`
+	Failed     int           `json:"failed"`
+	Empty      int           `json:"empty"`
+	Truncated  int           `json:"truncated"`
+	Larger     int           `json:"larger"`
+	BytesSaved int64         `json:"bytesSaved"`
+	Failures   []jsonFailure `json:"failures,omitempty"`
+
+	EstimatedBytesSaved *int64 `json:"estimatedBytesSaved,omitempty"`
+}
+
+var jsonMutex sync.Mutex
+
+func printJSON(v interface{}) {
+	jsonMutex.Lock()
+	defer jsonMutex.Unlock()
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	printAboveProgress(os.Stdout, string(data)+"\n")
+}
+
+func printJSONResult(path string, result resizer.Result, err error) {
+	out := jsonResult{
+		Type:           "file",
+		Path:           path,
+		DPI:            result.DPI,
+		OutputPath:     result.OutputPath,
+		EstimatedBytes: result.EstimatedBytes,
+		Quality:        result.Quality,
+		SSIM:           result.SSIM,
+		Skipped:        result.Skipped,
+		Larger:         result.Larger,
+	}
+	if result.OriginalW > 0 {
+		out.OriginalDimensions = &jsonDimensions{result.OriginalW, result.OriginalH}
+	}
+	if result.NewW > 0 {
+		out.NewDimensions = &jsonDimensions{result.NewW, result.NewH}
+	}
+	if err != nil {
+		out.Error = err.Error()
+	}
+	printJSON(out)
+}
+
+func (s *runSummary) printJSON() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := jsonSummary{
+		Type:       "summary",
+		Processed:  s.processed,
+		Skipped:    s.skipped,
+		Failed:     s.failed,
+		Empty:      s.empty,
+		Truncated:  s.truncated,
+		Larger:     len(s.larger),
+		BytesSaved: s.bytesSaved,
+	}
+	if s.estimated {
+		out.EstimatedBytesSaved = &s.estimatedSaved
+	}
+	for _, f := range s.failures {
+		out.Failures = append(out.Failures, jsonFailure{f.path, f.err.Error()})
+	}
+	printJSON(out)
+}
+
+// formatBytes renders a byte count using binary units, e.g. "2.3 GB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	sign := ""
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	if n < unit {
+		return fmt.Sprintf("%s%d B", sign, n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%s%.1f %cB", sign, float64(n)/float64(div), "KMGTPE"[exp])
+}
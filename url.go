@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"restore/pkg/resizer"
+)
+
+// defaultURLTimeout bounds each download unless --url-timeout says otherwise.
+const defaultURLTimeout = time.Minute
+
+// defaultMaxDownloadSize bounds each download unless --max-download-size says
+// otherwise. Downloads are held in memory, so without a limit one huge
+// response could exhaust it before --total-memory ever applies.
+const defaultMaxDownloadSize = "256MB"
+
+// isURL reports whether an input names an http or https URL rather than a
+// local path.
+func isURL(input string) bool {
+	lower := strings.ToLower(input)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// processURL downloads the image at rawURL into memory and resizes it like a
+// local file. The output is saved directly in the output directory, named as
+// urlOutputNames decided.
+func processURL(ctx context.Context, rawURL string, opts *options) (resizer.Result, error) {
+	if opts.inPlace {
+		return resizer.Result{}, fmt.Errorf("URLs cannot be resized in place; use --output instead")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return resizer.Result{}, fmt.Errorf("invalid URL: %w", err)
+	}
+	name, ok := opts.urlNames[rawURL]
+	if !ok {
+		name = urlFileName(u)
+	}
+
+	logDebug(fmt.Sprintf("Downloading %s", rawURL))
+	start := time.Now()
+	data, err := download(ctx, rawURL, opts.urlTimeout, opts.maxDownloadSize)
+	if err != nil {
+		return resizer.Result{}, err
+	}
+	logDebug(fmt.Sprintf("Downloaded %d bytes from %s in %s", len(data), rawURL, time.Since(start).Round(time.Millisecond)))
+
+	format := ""
+	if opts.Format == "" {
+		if _, detected, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			format = detected
+		}
+	}
+	outputExt := outputExtension(name, format, opts)
+
+	outputPathFor := func(width, height, dpi int) string {
+		outputFileName := expandNameTemplate(opts.nameTemplate, name, outputExt, width, height, dpi)
+		if opts.archive != nil {
+			if opts.archive.contains(outputFileName) {
+				logWarn(fmt.Sprintf("Skipping %s: the archive already has an entry named %s", rawURL, outputFileName))
+				return ""
+			}
+			return outputFileName
+		}
+		outputPath := filepath.Join(opts.outputDir, outputFileName)
+		if _, err := os.Stat(outputPath); err == nil && !opts.overwrite {
+			logInfo(fmt.Sprintf("Skipping existing file: %s", outputPath))
+			return ""
+		}
+		return outputPath
+	}
+
+	dpi := opts.dpi
+	if dpi == 0 {
+		if extractedDPI, err := resizer.ExtractDPIFrom(bytes.NewReader(data)); err == nil {
+			dpi = extractedDPI
+			logDebug(fmt.Sprintf("Extracted DPI for %s: %d", rawURL, dpi))
+		} else {
+			logDebug(fmt.Sprintf("No DPI found in %s, assuming %d for sizing: %v", rawURL, resizer.DefaultDPI, err))
+		}
+	}
+
+	result, err := resizeWithTimeout(ctx, opts, func(ctx context.Context) (resizer.Result, error) {
+		src := bytes.NewReader(data)
+		if opts.archive == nil {
+			return resizer.ResizeReader(ctx, src, rawURL, outputPathFor, dpi, &opts.Options)
+		}
+		return resizer.ResizeReaderTo(ctx, src, rawURL, outputPathFor, dpi, &opts.Options, func(name string, encode func(w io.Writer) error) error {
+			return opts.archive.add(name, time.Now(), encode)
+		})
+	})
+	if err == nil && !result.Skipped {
+		noteQualityIgnored(result.Format, opts)
+	}
+	return result, err
+}
+
+// download fetches rawURL, giving up after timeout or once the response is
+// over maxSize bytes. Zero disables either limit.
+func download(ctx context.Context, rawURL string, timeout time.Duration, maxSize int64) ([]byte, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("download gave up after %s (--url-timeout)", timeout)
+		}
+		return nil, fmt.Errorf("download failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed: %s", resp.Status)
+	}
+	if maxSize > 0 && resp.ContentLength > maxSize {
+		return nil, fmt.Errorf("download refused: the response is %s, over the limit of %s (--max-download-size)", formatBytes(resp.ContentLength), formatBytes(maxSize))
+	}
+
+	var body io.Reader = resp.Body
+	if maxSize > 0 {
+		// Read one byte past the limit to tell a response that fits exactly
+		// from one that goes on.
+		body = io.LimitReader(resp.Body, maxSize+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("download gave up after %s (--url-timeout)", timeout)
+		}
+		return nil, fmt.Errorf("download failed: %w", err)
+	}
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("download stopped: the response is over the limit of %s (--max-download-size)", formatBytes(maxSize))
+	}
+	return data, nil
+}
+
+// urlOutputNames returns the file name each URL among files is saved under.
+// Usually that is urlFileName, but URLs that would share a name get a short
+// hash of the whole URL added, so none of them replaces another's output. The
+// names depend only on the inputs, so re-runs and resumed runs reuse them.
+func urlOutputNames(files []batchFile) map[string]string {
+	names := map[string]string{}
+	claims := map[string]int{}
+	for _, file := range files {
+		u, err := url.Parse(file.path)
+		if !isURL(file.path) || err != nil {
+			continue
+		}
+		if _, seen := names[file.path]; seen {
+			continue
+		}
+		names[file.path] = urlFileName(u)
+		// Case-insensitive file systems treat Photo.jpg and photo.jpg as one.
+		claims[strings.ToLower(names[file.path])]++
+	}
+
+	for rawURL, name := range names {
+		if claims[strings.ToLower(name)] > 1 {
+			sum := sha256.Sum256([]byte(rawURL))
+			ext := path.Ext(name)
+			names[rawURL] = strings.TrimSuffix(name, ext) + "-" + hex.EncodeToString(sum[:4]) + ext
+		}
+	}
+	return names
+}
+
+// urlFileName returns the file name an output downloaded from u is named
+// after: the last part of its path, or its host name when the path is empty.
+func urlFileName(u *url.URL) string {
+	name := path.Base(u.Path)
+	if name == "." || name == "/" || name == "" {
+		name = u.Hostname()
+	}
+	// Keep the name usable on every platform.
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`<>:"/\|?*`, r) || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, name)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestURLFileName(t *testing.T) {
+	tests := []struct {
+		rawURL string
+		want   string
+	}{
+		{"https://example.com/images/photo.jpg", "photo.jpg"},
+		{"https://example.com/images/photo.jpg?size=large", "photo.jpg"},
+		{"https://example.com/", "example.com"},
+		{"https://example.com", "example.com"},
+		{"https://example.com:8080/a/b%3Ac.png", "b_c.png"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := urlFileName(u); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.rawURL, got, tt.want)
+		}
+	}
+}
+
+func TestURLOutputNames(t *testing.T) {
+	files := []batchFile{
+		{path: "https://a.example.com/photo.jpg"},
+		{path: "https://b.example.com/photo.jpg"},
+		{path: "https://c.example.com/Photo.JPG"},
+		{path: "https://a.example.com/other.png"},
+		{path: "https://a.example.com/photo.jpg"},
+		{path: "/local/photo.jpg", root: "/local"},
+	}
+	names := urlOutputNames(files)
+
+	if len(names) != 4 {
+		t.Fatalf("named %d URLs, want 4: %v", len(names), names)
+	}
+	if got := names["https://a.example.com/other.png"]; got != "other.png" {
+		t.Errorf("a unique name changed to %q", got)
+	}
+
+	// The three URLs that would all be saved as photo.jpg on a
+	// case-insensitive file system each get a different suffix.
+	seen := map[string]bool{}
+	for _, rawURL := range []string{"https://a.example.com/photo.jpg", "https://b.example.com/photo.jpg", "https://c.example.com/Photo.JPG"} {
+		name := names[rawURL]
+		if !strings.HasPrefix(strings.ToLower(name), "photo-") || !strings.HasSuffix(strings.ToLower(name), ".jpg") {
+			t.Errorf("%s: got %q, want photo-<hash>.jpg", rawURL, name)
+		}
+		if seen[strings.ToLower(name)] {
+			t.Errorf("%s: %q is used twice", rawURL, name)
+		}
+		seen[strings.ToLower(name)] = true
+	}
+
+	// Names depend only on the inputs, so a resumed run reuses them.
+	again := urlOutputNames([]batchFile{files[2], files[0]})
+	if again["https://a.example.com/photo.jpg"] != names["https://a.example.com/photo.jpg"] {
+		t.Errorf("the name changed between runs: %q, then %q", names["https://a.example.com/photo.jpg"], again["https://a.example.com/photo.jpg"])
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// zipArchive collects the outputs of a run in a single ZIP file for --zip.
+// Workers encode into their own buffers and only hold the lock while copying
+// a finished image into the archive.
+type zipArchive struct {
+	path     string
+	tempFile *os.File
+
+	mu     sync.Mutex
+	writer *zip.Writer
+	names  map[string]bool
+	closed bool
+}
+
+// createZipArchive starts an archive that becomes visible at path when it is
+// closed, so an interrupted run never leaves a truncated archive behind.
+func createZipArchive(path string) (*zipArchive, error) {
+	tempFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive: %w", err)
+	}
+	// Temporary files are private; give the archive the usual permissions.
+	tempFile.Chmod(0o644)
+	return &zipArchive{
+		path:     path,
+		tempFile: tempFile,
+		writer:   zip.NewWriter(tempFile),
+		names:    map[string]bool{},
+	}, nil
+}
+
+// contains reports whether an entry called name has already been added.
+func (a *zipArchive) contains(name string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.names[name]
+}
+
+// add encodes an image and stores it as the entry name, stamped with
+// modified.
+func (a *zipArchive) add(name string, modified time.Time, encode func(w io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := encode(&buf); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return fmt.Errorf("archive %s is already closed", a.path)
+	}
+	if a.names[name] {
+		return fmt.Errorf("archive already has an entry named %s", name)
+	}
+
+	// Images are compressed already, so deflating them again gains little.
+	entry, err := a.writer.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: modified})
+	if err != nil {
+		return fmt.Errorf("failed to add %s to archive: %w", name, err)
+	}
+	if _, err := entry.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to add %s to archive: %w", name, err)
+	}
+	a.names[name] = true
+	return nil
+}
+
+// abort discards an archive that was never closed, for runs that end with
+// an error.
+func (a *zipArchive) abort() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return
+	}
+	a.closed = true
+	a.tempFile.Close()
+	os.Remove(a.tempFile.Name())
+}
+
+// close finishes the archive and moves it to its final path.
+func (a *zipArchive) close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closed = true
+
+	tempPath := a.tempFile.Name()
+	err := a.writer.Close()
+	if closeErr := a.tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempPath, a.path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write archive %s: %w", a.path, err)
+	}
+	return nil
+}